@@ -0,0 +1,322 @@
+// Package cli is the standalone mode of the provider executable for cloud operators,
+// it manages the auto allocated topology with the openstack package directly, without Terraform.
+//
+//	terraform-provider-openstack-auto-topology topology get|delete|check|list [flags]
+//
+// Credential is resolved the same way as the provider without a provider block,
+// from OS_* environment variables, or from clouds.yaml if OS_CLOUD (or --cloud) is set.
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"gitlab.com/cyverse/openstack-auto-allocated-topology/openstack"
+)
+
+// CommandName is the first argument that switches the executable into CLI mode
+const CommandName = "topology"
+
+// exit codes
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+const usage = `Usage: %[1]s topology <command> [flags]
+
+Commands:
+  get     get the auto allocated topology of a project, create it if absent (unless --create-if-missing=false)
+  delete  delete the auto allocated topology of a project
+  check   check (dry-run) whether the prerequisites of auto allocated topology are met for a project
+  list    list the auto allocated topologies of the projects that the user has role on
+
+Credential is loaded from OS_* environment variables, or from clouds.yaml if OS_CLOUD (or --cloud) is set,
+in which case OS_* environment variables that are set still take priority over clouds.yaml.
+Run "%[1]s topology <command> -h" for the flags of a command.
+`
+
+// options are the flags of a command
+type options struct {
+	projectID       string
+	projectName     string
+	region          string
+	cloud           string
+	output          string
+	createIfMissing bool
+}
+
+// Run runs the CLI with the arguments (starting with "topology"), and returns the exit code.
+// version is used in the User-Agent of requests.
+func Run(ctx context.Context, programName string, args []string, version string, stdout, stderr io.Writer) int {
+	if len(args) < 2 || args[0] != CommandName {
+		fmt.Fprintf(stderr, usage, programName)
+		return exitUsage
+	}
+	command := args[1]
+	switch command {
+	case "get", "delete", "check", "list":
+	case "-h", "-help", "--help", "help":
+		fmt.Fprintf(stdout, usage, programName)
+		return exitOK
+	default:
+		fmt.Fprintf(stderr, "unknown command %s\n\n", command)
+		fmt.Fprintf(stderr, usage, programName)
+		return exitUsage
+	}
+
+	opts, err := parseFlags(programName, command, args[2:], stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	if err != nil {
+		return exitUsage
+	}
+
+	client, err := newClient(ctx, opts, version)
+	if err != nil {
+		printError(stderr, err)
+		return exitError
+	}
+	err = runCommand(ctx, client, command, opts, stdout)
+	if err != nil {
+		printError(stderr, err)
+		return exitError
+	}
+	return exitOK
+}
+
+func parseFlags(programName, command string, args []string, stderr io.Writer) (options, error) {
+	var opts options
+	flagSet := flag.NewFlagSet(fmt.Sprintf("%s topology %s", programName, command), flag.ContinueOnError)
+	flagSet.SetOutput(stderr)
+	if command != "list" {
+		flagSet.StringVar(&opts.projectID, "project", "", "project ID, defaults to the project of the credential")
+		flagSet.StringVar(&opts.projectName, "project-name", "", "project name, used if --project is not specified")
+	}
+	if command == "get" {
+		flagSet.BoolVar(&opts.createIfMissing, "create-if-missing", true, "create the topology if the project does not have one")
+	}
+	flagSet.StringVar(&opts.region, "region", "", "region name, defaults to OS_REGION_NAME")
+	flagSet.StringVar(&opts.cloud, "cloud", "", "name of the cloud in clouds.yaml, defaults to OS_CLOUD")
+	flagSet.StringVar(&opts.output, "output", "human", "output format, human or json")
+
+	err := flagSet.Parse(args)
+	if err != nil {
+		return options{}, err
+	}
+	if flagSet.NArg() > 0 {
+		fmt.Fprintf(stderr, "unexpected arguments %s\n", strings.Join(flagSet.Args(), " "))
+		flagSet.Usage()
+		return options{}, fmt.Errorf("unexpected arguments")
+	}
+	if opts.output != "human" && opts.output != "json" {
+		fmt.Fprintf(stderr, "output format %s is not supported, expect human or json\n", opts.output)
+		return options{}, fmt.Errorf("unsupported output format")
+	}
+	return opts, nil
+}
+
+// newClient loads the credential and authenticates, flags take priority over environment variables
+func newClient(ctx context.Context, opts options, version string) (*openstack.Client, error) {
+	cred, err := openstack.ResolveCredential(opts.cloud)
+	if err != nil {
+		return nil, err
+	}
+	if opts.region != "" {
+		cred.RegionName = opts.region
+	}
+
+	client := openstack.NewClient()
+	client.SetUserAgent(fmt.Sprintf("%s/%s cli", openstack.UserAgentProductName, version))
+	err = client.Auth(ctx, cred)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func runCommand(ctx context.Context, client openstack.IdentityAPI, command string, opts options, stdout io.Writer) error {
+	regionName := client.CurrentRegion()
+	networkClient, err := client.Network(ctx, regionName)
+	if err != nil {
+		return err
+	}
+	if command == "list" {
+		return listTopologies(ctx, client, networkClient, regionName, opts, stdout)
+	}
+
+	projectID, err := getProjectID(ctx, client, opts)
+	if err != nil {
+		return err
+	}
+	switch command {
+	case "get":
+		return getTopology(ctx, networkClient, regionName, projectID, opts, stdout)
+	case "delete":
+		return deleteTopology(ctx, networkClient, regionName, projectID, opts, stdout)
+	case "check":
+		return checkTopology(ctx, networkClient, regionName, projectID, opts, stdout)
+	}
+	return fmt.Errorf("unknown command %s", command)
+}
+
+// getProjectID resolves the project in the same hierarchy as the provider: project ID, project name, then the current project
+func getProjectID(ctx context.Context, client openstack.IdentityAPI, opts options) (string, error) {
+	if opts.projectID != "" {
+		return opts.projectID, nil
+	}
+	if opts.projectName != "" {
+		return client.LookupProjectByName(ctx, opts.projectName)
+	}
+	projectID, _ := client.CurrentProject()
+	if projectID == "" {
+		return "", fmt.Errorf("cannot obtain project ID, specify --project or --project-name")
+	}
+	return projectID, nil
+}
+
+// topologyOutput is the JSON output of get
+type topologyOutput struct {
+	Region string `json:"region"`
+	Exists bool   `json:"exists"`
+	openstack.AutoAllocatedTopology
+}
+
+func getTopology(ctx context.Context, networkClient openstack.NetworkAPI, regionName, projectID string, opts options, stdout io.Writer) error {
+	var (
+		topology *openstack.AutoAllocatedTopology
+		err      error
+	)
+	if opts.createIfMissing {
+		topology, err = networkClient.GetAutoAllocatedTopology(ctx, projectID)
+	} else {
+		topology, err = networkClient.FindAutoAllocatedTopology(ctx, projectID)
+	}
+	if err != nil {
+		return err
+	}
+	output := topologyOutput{Region: regionName}
+	if topology == nil {
+		output.ProjectID = projectID
+		return printOutput(stdout, opts, output, func(w io.Writer) {
+			fmt.Fprintf(w, "project %s does not have an auto allocated topology\n", projectID)
+		})
+	}
+	err = networkClient.GetAutoAllocatedTopologyDetails(ctx, topology)
+	if err != nil {
+		return err
+	}
+	output.Exists = true
+	output.AutoAllocatedTopology = *topology
+
+	return printOutput(stdout, opts, output, func(w io.Writer) {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "project:\t%s\n", output.ProjectID)
+		fmt.Fprintf(tw, "region:\t%s\n", output.Region)
+		fmt.Fprintf(tw, "network:\t%s (%s)\n", output.Name, output.NetworkID)
+		fmt.Fprintf(tw, "mtu:\t%d\n", output.MTU)
+		for _, subnet := range output.Subnets {
+			fmt.Fprintf(tw, "subnet:\t%s %s (gateway %s)\n", subnet.ID, subnet.CIDR, subnet.GatewayIP)
+		}
+		fmt.Fprintf(tw, "router:\t%s\n", output.RouterID)
+		fmt.Fprintf(tw, "external network:\t%s\n", output.ExternalNetworkID)
+		for _, fixedIP := range output.ExternalFixedIPs {
+			fmt.Fprintf(tw, "external ip:\t%s\n", fixedIP.IPAddress)
+		}
+		tw.Flush()
+	})
+}
+
+// statusOutput is the JSON output of delete and check
+type statusOutput struct {
+	Region    string `json:"region"`
+	ProjectID string `json:"project_id"`
+	Deleted   bool   `json:"deleted,omitempty"`
+	OK        bool   `json:"ok,omitempty"`
+}
+
+func deleteTopology(ctx context.Context, networkClient openstack.NetworkAPI, regionName, projectID string, opts options, stdout io.Writer) error {
+	// Neutron does not fail to delete a topology that does not exist
+	err := networkClient.DeleteAutoAllocatedTopology(ctx, projectID)
+	if err != nil {
+		return err
+	}
+	output := statusOutput{Region: regionName, ProjectID: projectID, Deleted: true}
+	return printOutput(stdout, opts, output, func(w io.Writer) {
+		fmt.Fprintf(w, "deleted auto allocated topology of project %s\n", projectID)
+	})
+}
+
+func checkTopology(ctx context.Context, networkClient openstack.NetworkAPI, regionName, projectID string, opts options, stdout io.Writer) error {
+	err := networkClient.ValidateAutoAllocatedTopology(ctx, projectID)
+	if err != nil {
+		return err
+	}
+	output := statusOutput{Region: regionName, ProjectID: projectID, OK: true}
+	return printOutput(stdout, opts, output, func(w io.Writer) {
+		fmt.Fprintf(w, "auto allocated topology prerequisites are met for project %s\n", projectID)
+	})
+}
+
+// listOutput is an entry in the JSON output of list
+type listOutput struct {
+	ProjectID   string `json:"project_id"`
+	ProjectName string `json:"project_name"`
+	NetworkID   string `json:"network_id"`
+}
+
+// listTopologies lists the existing topologies of the projects that the user has role on, nothing is created
+func listTopologies(ctx context.Context, client openstack.IdentityAPI, networkClient openstack.NetworkAPI, regionName string, opts options, stdout io.Writer) error {
+	projects, err := client.ListProjects(ctx)
+	if err != nil {
+		return err
+	}
+	output := make([]listOutput, 0, len(projects))
+	for _, project := range projects {
+		topology, err := networkClient.FindAutoAllocatedTopology(ctx, project.ID)
+		if err != nil {
+			return fmt.Errorf("fail to look up auto allocated topology of project %s, %w", project.ID, err)
+		}
+		if topology == nil {
+			continue
+		}
+		output = append(output, listOutput{ProjectID: project.ID, ProjectName: project.Name, NetworkID: topology.NetworkID})
+	}
+	return printOutput(stdout, opts, output, func(w io.Writer) {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "PROJECT ID\tPROJECT NAME\tNETWORK ID")
+		for _, entry := range output {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", entry.ProjectID, entry.ProjectName, entry.NetworkID)
+		}
+		tw.Flush()
+	})
+}
+
+// printOutput prints output as JSON, or in human readable format with printHuman
+func printOutput(stdout io.Writer, opts options, output interface{}, printHuman func(w io.Writer)) error {
+	if opts.output == "json" {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(output)
+	}
+	printHuman(stdout)
+	return nil
+}
+
+// printError prints the error with the same summary and hint as the diagnostics of the provider
+func printError(stderr io.Writer, err error) {
+	summary, detail := openstack.DescribeError(err)
+	if detail == "" {
+		fmt.Fprintf(stderr, "Error: %s\n", summary)
+		return
+	}
+	fmt.Fprintf(stderr, "Error: %s\n\n%s\n", summary, detail)
+}
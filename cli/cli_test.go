@@ -0,0 +1,213 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"gitlab.com/cyverse/openstack-auto-allocated-topology/openstack"
+	"gitlab.com/cyverse/openstack-auto-allocated-topology/openstack/fake"
+	"gitlab.com/cyverse/openstack-auto-allocated-topology/openstack/mock"
+)
+
+// TestMain clears OS_* environment variables, tests set the ones pointing to the fake cloud
+func TestMain(m *testing.M) {
+	for _, env := range os.Environ() {
+		if strings.HasPrefix(env, "OS_") {
+			os.Unsetenv(strings.SplitN(env, "=", 2)[0])
+		}
+	}
+	os.Exit(m.Run())
+}
+
+// setFakeCredential sets the environment variables of application credential of the fake cloud, as an openrc would
+func setFakeCredential(t *testing.T, server *fake.Server) {
+	t.Setenv("OS_AUTH_URL", server.AuthURL())
+	t.Setenv("OS_REGION_NAME", fake.RegionName)
+	t.Setenv("OS_APPLICATION_CREDENTIAL_ID", fake.ApplicationCredentialID)
+	t.Setenv("OS_APPLICATION_CREDENTIAL_SECRET", fake.ApplicationCredentialSecret)
+}
+
+func run(args ...string) (exitCode int, stdout string, stderr string) {
+	var outBuf, errBuf bytes.Buffer
+	exitCode = Run(context.Background(), "test", args, "test", &outBuf, &errBuf)
+	return exitCode, outBuf.String(), errBuf.String()
+}
+
+func TestTopologyLifecycle(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	setFakeCredential(t, server)
+
+	exitCode, stdout, stderr := run("topology", "get", "--create-if-missing=false")
+	if exitCode != exitOK {
+		t.Fatalf("exit code %d, %s", exitCode, stderr)
+	}
+	if !strings.Contains(stdout, "does not have an auto allocated topology") {
+		t.Errorf("expected no topology, got %s", stdout)
+	}
+
+	exitCode, stdout, stderr = run("topology", "get", "--output", "json")
+	if exitCode != exitOK {
+		t.Fatalf("exit code %d, %s", exitCode, stderr)
+	}
+	var output topologyOutput
+	err := json.Unmarshal([]byte(stdout), &output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !output.Exists || output.NetworkID != server.TopologyNetworkID(fake.ProjectID) || output.Region != fake.RegionName {
+		t.Errorf("unexpected output %s", stdout)
+	}
+	if output.Name != "auto_allocated_network" || output.MTU != 1450 || len(output.Subnets) != 1 || output.ExternalNetworkID != fake.ExternalNetworkID {
+		t.Errorf("details are missing in output %s", stdout)
+	}
+
+	exitCode, stdout, stderr = run("topology", "list")
+	if exitCode != exitOK {
+		t.Fatalf("exit code %d, %s", exitCode, stderr)
+	}
+	if !strings.Contains(stdout, fake.ProjectID) || strings.Contains(stdout, "fake-other-project-id") {
+		t.Errorf("expected only the topology of %s, got %s", fake.ProjectID, stdout)
+	}
+
+	exitCode, _, stderr = run("topology", "delete", "--project", fake.ProjectID)
+	if exitCode != exitOK {
+		t.Fatalf("exit code %d, %s", exitCode, stderr)
+	}
+	if server.TopologyNetworkID(fake.ProjectID) != "" {
+		t.Error("topology is not deleted")
+	}
+}
+
+func TestTopologyGetByProjectName(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	setFakeCredential(t, server)
+
+	exitCode, stdout, stderr := run("topology", "get", "--project-name", "fake-other-project")
+	if exitCode != exitOK {
+		t.Fatalf("exit code %d, %s", exitCode, stderr)
+	}
+	networkID := server.TopologyNetworkID("fake-other-project-id")
+	if networkID == "" || !strings.Contains(stdout, networkID) {
+		t.Errorf("expected topology of fake-other-project, got %s", stdout)
+	}
+	if server.TopologyNetworkID(fake.ProjectID) != "" {
+		t.Error("topology of the current project should not be created")
+	}
+}
+
+func TestTopologyCheck(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	setFakeCredential(t, server)
+
+	exitCode, stdout, stderr := run("topology", "check", "--output", "json")
+	if exitCode != exitOK {
+		t.Fatalf("exit code %d, %s", exitCode, stderr)
+	}
+	if !strings.Contains(stdout, `"ok": true`) {
+		t.Errorf("unexpected output %s", stdout)
+	}
+
+	server.SetDefaultExternalNetwork(false)
+	exitCode, _, stderr = run("topology", "check")
+	if exitCode != exitError {
+		t.Fatalf("expected exit code %d, got %d", exitError, exitCode)
+	}
+	for _, expected := range []string{"prerequisites are not met", "request ID: req-", "openstack network set --default"} {
+		if !strings.Contains(stderr, expected) {
+			t.Errorf("expected error to contain %q, got %s", expected, stderr)
+		}
+	}
+	if server.TopologyNetworkID(fake.ProjectID) != "" {
+		t.Error("check should not create the topology")
+	}
+}
+
+func TestRegionFlag(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	setFakeCredential(t, server)
+
+	exitCode, _, stderr := run("topology", "get", "--region", "RegionTwo")
+	if exitCode != exitError {
+		t.Fatalf("expected exit code %d, got %d", exitError, exitCode)
+	}
+	if !strings.Contains(stderr, "RegionTwo") {
+		t.Errorf("expected error to name the region, got %s", stderr)
+	}
+}
+
+func TestWrongCredential(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	setFakeCredential(t, server)
+	t.Setenv("OS_APPLICATION_CREDENTIAL_SECRET", "wrong")
+
+	exitCode, _, stderr := run("topology", "get")
+	if exitCode != exitError {
+		t.Fatalf("expected exit code %d, got %d", exitError, exitCode)
+	}
+	if !strings.Contains(stderr, string(openstack.AuthMethodApplicationCredentialID)) {
+		t.Errorf("expected error to name the auth method, got %s", stderr)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	testCases := []struct {
+		args     []string
+		exitCode int
+	}{
+		{[]string{"topology"}, exitUsage},
+		{[]string{"topology", "create"}, exitUsage},
+		{[]string{"topology", "get", "--output", "yaml"}, exitUsage},
+		{[]string{"topology", "get", "extra"}, exitUsage},
+		{[]string{"topology", "list", "--project", "p"}, exitUsage},
+		{[]string{"topology", "help"}, exitOK},
+		{[]string{"topology", "get", "-h"}, exitOK},
+	}
+	for _, tc := range testCases {
+		exitCode, _, _ := run(tc.args...)
+		if exitCode != tc.exitCode {
+			t.Errorf("%v: expected exit code %d, got %d", tc.args, tc.exitCode, exitCode)
+		}
+	}
+}
+
+func TestDeleteAlreadyDeleted(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	setFakeCredential(t, server)
+
+	for i := 0; i < 2; i++ {
+		exitCode, _, stderr := run("topology", "delete", "--project", fake.ProjectID)
+		if exitCode != exitOK {
+			t.Fatalf("exit code %d, %s", exitCode, stderr)
+		}
+	}
+
+	server.SetAutoAllocation(false)
+	exitCode, _, stderr := run("topology", "delete", "--project", fake.ProjectID)
+	if exitCode != exitError || !strings.Contains(stderr, "is not enabled") {
+		t.Errorf("expected extension missing error, got exit code %d, %s", exitCode, stderr)
+	}
+}
+
+func TestUnscopedCredential(t *testing.T) {
+	network := &mock.NetworkAPI{}
+	identity := &mock.IdentityAPI{NetworkAPI: network}
+
+	var stdout bytes.Buffer
+	err := runCommand(context.Background(), identity, "get", options{output: "human", createIfMissing: true}, &stdout)
+	if err == nil || !strings.Contains(err.Error(), "--project") {
+		t.Errorf("expected error asking for --project, got %v", err)
+	}
+	if len(network.Calls()) != 0 {
+		t.Errorf("expected no network calls, got %+v", network.Calls())
+	}
+}
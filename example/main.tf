@@ -12,19 +12,53 @@ terraform {
 }
 
 provider "openstack-auto-topology" {
+    # all attributes are optional, OS_* environment variables are used as fallbacks
     # auth_url = "https://cyverse.org"
+    # region = "MY_REGION"
+    # application_credential_id = "MY_APP_CRED_ID"
+    # application_credential_secret = "MY_APP_CRED_SECRET"
 }
 
+# use an alias to target another cloud in the same run
+# provider "openstack-auto-topology" {
+#     alias = "other"
+#     auth_url = "https://other.cloud.example"
+#     user_name = "MY_USERNAME"
+#     password = "MY_PASSWORD"
+#     user_domain_name = "Default"
+#     project_name = "MY_PROJECT"
+# }
+
 data "openstack-auto-topology_auto_allocated_topology" "network" {
     # region_name = "MY_REGION" # you can override the region name from application credential
     # project_id = "MY_PROJECT_ID" # you can override the project ID, project ID takes priority over project name
     # project_name = "MY_PROJECT_NAME" # you can override the project name
+    # create_if_missing = false # only look up existing topology, check the exists attribute
 }
 
+# resource "openstack-auto-topology_auto_allocated_topology" "network" {
+#     project_id = "MY_PROJECT_ID"
+#     timeouts {
+#         create = "10m"
+#     }
+# }
+
+# fails with the missing prerequisite if auto allocated topology cannot be created for the project
+# data "openstack-auto-topology_auto_allocated_topology_check" "check" {
+# }
+
 output "network_id" {
-  value = data.openstack-auto-topology_auto_allocated_topology.network.id
+  value = data.openstack-auto-topology_auto_allocated_topology.network.network_id
 }
 
 output "network_name" {
   value = data.openstack-auto-topology_auto_allocated_topology.network.name
 }
+
+output "subnets" {
+  value = data.openstack-auto-topology_auto_allocated_topology.network.subnets
+}
+
+output "router_id" {
+  value = data.openstack-auto-topology_auto_allocated_topology.network.router_id
+}
@@ -1,15 +1,46 @@
 package main
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/plugin"
+	"gitlab.com/cyverse/openstack-auto-allocated-topology/cli"
 	"gitlab.com/cyverse/openstack-auto-allocated-topology/provider"
 )
 
+// set by goreleaser via -ldflags "-X main.version=... -X main.commit=..."
+var (
+	version = "dev"
+	commit  = ""
+)
+
 func main() {
+	// CLI mode for operators, Terraform launches the plugin without arguments
+	if len(os.Args) > 1 && os.Args[1] == cli.CommandName {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		exitCode := cli.Run(ctx, filepath.Base(os.Args[0]), os.Args[1:], providerVersion(), os.Stdout, os.Stderr)
+		stop()
+		os.Exit(exitCode)
+	}
+
 	plugin.Serve(&plugin.ServeOpts{
 		ProviderFunc: func() *schema.Provider {
-			return provider.New()
+			return provider.New(providerVersion())
 		},
 	})
 }
+
+// providerVersion is the version reported by the provider, with the commit if it is known
+func providerVersion() string {
+	if commit == "" {
+		return version
+	}
+	if len(commit) > 7 {
+		return version + "+" + commit[:7]
+	}
+	return version + "+" + commit
+}
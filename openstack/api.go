@@ -0,0 +1,39 @@
+package openstack
+
+import "context"
+
+// IdentityAPI is the Identity (Keystone) side of the client that the provider depends on,
+// it also hands out NetworkAPI for a region, since the endpoint comes from the catalog of the token.
+// Client implements it, see package mock for a test double.
+type IdentityAPI interface {
+	// CurrentProject returns the project that the token is scoped to, empty if unscoped
+	CurrentProject() (id string, name string)
+	// CurrentRegion returns the region from the credential, may be empty
+	CurrentRegion() (name string)
+	// LookupProjectByName looks up the ID of a project (that the user has role on) by its name
+	LookupProjectByName(ctx context.Context, projectName string) (id string, err error)
+	// ListProjects lists the projects that the user has role on
+	ListProjects(ctx context.Context) ([]Project, error)
+	// Network returns the NetworkAPI for a region, empty regionName means the region from the credential
+	Network(ctx context.Context, regionName string) (NetworkAPI, error)
+}
+
+// NetworkAPI is the Network (Neutron) operations on the auto allocated topology that the provider depends on.
+// NetworkClient implements it, see package mock for a test double.
+type NetworkAPI interface {
+	// GetAutoAllocatedTopology gets the topology of a project, creates it if absent
+	GetAutoAllocatedTopology(ctx context.Context, projectID string) (*AutoAllocatedTopology, error)
+	// FindAutoAllocatedTopology looks up the topology of a project without creating it, nil if absent
+	FindAutoAllocatedTopology(ctx context.Context, projectID string) (*AutoAllocatedTopology, error)
+	// GetAutoAllocatedTopologyDetails fills in network name and MTU, subnets, router and external gateway of the topology
+	GetAutoAllocatedTopologyDetails(ctx context.Context, topology *AutoAllocatedTopology) error
+	// ValidateAutoAllocatedTopology checks the prerequisites (dry-run) of the topology of a project
+	ValidateAutoAllocatedTopology(ctx context.Context, projectID string) error
+	// DeleteAutoAllocatedTopology deletes the topology of a project
+	DeleteAutoAllocatedTopology(ctx context.Context, projectID string) error
+}
+
+var (
+	_ IdentityAPI = (*Client)(nil)
+	_ NetworkAPI  = NetworkClient{}
+)
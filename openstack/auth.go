@@ -1,35 +1,250 @@
 package openstack
 
 import (
+	"fmt"
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack"
 	tokensv3 "github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
+	"github.com/kelseyhightower/envconfig"
+	"os"
+	"reflect"
+	"strings"
 	"time"
 )
 
 // CredentialEnv is env vars for .openrc for openstack credential
 type CredentialEnv struct {
+	Cloud                       string `envconfig:"OS_CLOUD"`
 	RegionName                  string `envconfig:"OS_REGION_NAME"`
 	Interface                   string `envconfig:"OS_INTERFACE" default:"public"`
 	AuthURL                     string `envconfig:"OS_AUTH_URL"`
 	ApplicationCredentialSecret string `envconfig:"OS_APPLICATION_CREDENTIAL_SECRET"`
 	ApplicationCredentialID     string `envconfig:"OS_APPLICATION_CREDENTIAL_ID"`
+	ApplicationCredentialName   string `envconfig:"OS_APPLICATION_CREDENTIAL_NAME"`
 	AuthType                    string `envconfig:"OS_AUTH_TYPE"`
 	IdentityAPIVersion          string `envconfig:"OS_IDENTITY_API_VERSION"`
+	Token                       string `envconfig:"OS_TOKEN"`
+	Username                    string `envconfig:"OS_USERNAME"`
+	UserID                      string `envconfig:"OS_USER_ID"`
+	Password                    string `envconfig:"OS_PASSWORD"`
+	UserDomainName              string `envconfig:"OS_USER_DOMAIN_NAME"`
+	UserDomainID                string `envconfig:"OS_USER_DOMAIN_ID"`
+	ProjectID                   string `envconfig:"OS_PROJECT_ID"`
+	ProjectName                 string `envconfig:"OS_PROJECT_NAME"`
+	ProjectDomainName           string `envconfig:"OS_PROJECT_DOMAIN_NAME"`
+	ProjectDomainID             string `envconfig:"OS_PROJECT_DOMAIN_ID"`
+	CACertFile                  string `envconfig:"OS_CACERT"`
+	Cert                        string `envconfig:"OS_CERT"`
+	Key                         string `envconfig:"OS_KEY"`
+	Insecure                    bool   `envconfig:"OS_INSECURE"`
+}
+
+// LoadCredentialFromEnv loads credential from OS_* environment variables (e.g. sourced from openrc)
+func LoadCredentialFromEnv() (CredentialEnv, error) {
+	var cred CredentialEnv
+	err := envconfig.Process("", &cred)
+	if err != nil {
+		return CredentialEnv{}, fmt.Errorf("fail to load application credential from environment variables, %w", err)
+	}
+	return cred, nil
+}
+
+// ResolveCredential loads credential from clouds.yaml if a cloud is specified (cloud, or OS_CLOUD if cloud is empty),
+// otherwise from OS_* environment variables.
+// As openstackclient, OS_* environment variables that are set (non-empty) take priority over clouds.yaml,
+// e.g. OS_REGION_NAME selects the region of the cloud.
+func ResolveCredential(cloud string) (CredentialEnv, error) {
+	envCred, err := LoadCredentialFromEnv()
+	if err != nil {
+		return CredentialEnv{}, err
+	}
+	if cloud != "" {
+		envCred.Cloud = cloud
+	}
+	if envCred.Cloud == "" {
+		return envCred, nil
+	}
+	cred, err := LoadCloudCredential(envCred.Cloud)
+	if err != nil {
+		return CredentialEnv{}, err
+	}
+	overlayEnv(&cred, envCred)
+	cred.Cloud = envCred.Cloud
+	return cred, nil
+}
+
+// overlayEnv overrides the fields of cred with the ones of envCred whose environment variable is set to non-empty
+func overlayEnv(cred *CredentialEnv, envCred CredentialEnv) {
+	credValue := reflect.ValueOf(cred).Elem()
+	envValue := reflect.ValueOf(envCred)
+	for i := 0; i < credValue.NumField(); i++ {
+		envName := credValue.Type().Field(i).Tag.Get("envconfig")
+		if envName == "" || os.Getenv(envName) == "" {
+			continue
+		}
+		credValue.Field(i).Set(envValue.Field(i))
+	}
+}
+
+// AuthMethod is the method used to authenticate with Identity (Keystone) API
+type AuthMethod string
+
+// supported authentication methods
+const (
+	AuthMethodApplicationCredentialID   AuthMethod = "application credential (by ID)"
+	AuthMethodApplicationCredentialName AuthMethod = "application credential (by name)"
+	AuthMethodToken                     AuthMethod = "token"
+	AuthMethodPassword                  AuthMethod = "password"
+)
+
+// AuthMethod chooses the authentication method for the credential.
+// If auth type (OS_AUTH_TYPE) is specified, then the method is chosen based on it,
+// otherwise it is chosen by what the credential supplies in the following order:
+// - application credential ID
+// - application credential name
+// - token
+// - username/user ID and password
+func (cred CredentialEnv) AuthMethod() (AuthMethod, error) {
+	switch strings.TrimPrefix(strings.ToLower(cred.AuthType), "v3") {
+	case "":
+	case "applicationcredential":
+		if cred.ApplicationCredentialID != "" {
+			return AuthMethodApplicationCredentialID, nil
+		}
+		return AuthMethodApplicationCredentialName, nil
+	case "token":
+		return AuthMethodToken, nil
+	case "password":
+		return AuthMethodPassword, nil
+	default:
+		return "", fmt.Errorf("auth type %s is not supported, supported types are v3applicationcredential, v3token and v3password", cred.AuthType)
+	}
+	switch {
+	case cred.ApplicationCredentialID != "":
+		return AuthMethodApplicationCredentialID, nil
+	case cred.ApplicationCredentialName != "":
+		return AuthMethodApplicationCredentialName, nil
+	case cred.Token != "":
+		return AuthMethodToken, nil
+	case cred.Username != "" || cred.UserID != "":
+		return AuthMethodPassword, nil
+	}
+	return "", fmt.Errorf("no usable credential, supply one of application credential, token or username/password")
+}
+
+// AuthOptions converts the credential into options for gophercloud, using the given authentication method.
+func (cred CredentialEnv) AuthOptions(method AuthMethod) (gophercloud.AuthOptions, error) {
+	if cred.AuthURL == "" {
+		return gophercloud.AuthOptions{}, fmt.Errorf("auth URL is missing, set auth_url in provider or OS_AUTH_URL")
+	}
+	switch method {
+	case AuthMethodApplicationCredentialID:
+		if cred.ApplicationCredentialSecret == "" {
+			return gophercloud.AuthOptions{}, fmt.Errorf("application credential secret is missing, set application_credential_secret in provider or OS_APPLICATION_CREDENTIAL_SECRET")
+		}
+		return gophercloud.AuthOptions{
+			IdentityEndpoint:            cred.AuthURL,
+			ApplicationCredentialID:     cred.ApplicationCredentialID,
+			ApplicationCredentialSecret: cred.ApplicationCredentialSecret,
+		}, nil
+	case AuthMethodApplicationCredentialName:
+		if cred.ApplicationCredentialName == "" || cred.ApplicationCredentialSecret == "" {
+			return gophercloud.AuthOptions{}, fmt.Errorf("application credential name and secret are required, set application_credential_name/application_credential_secret in provider or OS_APPLICATION_CREDENTIAL_NAME/OS_APPLICATION_CREDENTIAL_SECRET")
+		}
+		opts := gophercloud.AuthOptions{
+			IdentityEndpoint:            cred.AuthURL,
+			ApplicationCredentialName:   cred.ApplicationCredentialName,
+			ApplicationCredentialSecret: cred.ApplicationCredentialSecret,
+		}
+		err := cred.setUser(&opts)
+		if err != nil {
+			return gophercloud.AuthOptions{}, fmt.Errorf("application credential name is owned by a user, %w", err)
+		}
+		return opts, nil
+	case AuthMethodToken:
+		if cred.Token == "" {
+			return gophercloud.AuthOptions{}, fmt.Errorf("token is missing, set token in provider or OS_TOKEN")
+		}
+		return gophercloud.AuthOptions{
+			IdentityEndpoint: cred.AuthURL,
+			TokenID:          cred.Token,
+			Scope:            cred.projectScope(),
+		}, nil
+	case AuthMethodPassword:
+		if cred.Password == "" {
+			return gophercloud.AuthOptions{}, fmt.Errorf("password is missing, set password in provider or OS_PASSWORD")
+		}
+		opts := gophercloud.AuthOptions{
+			IdentityEndpoint: cred.AuthURL,
+			Password:         cred.Password,
+			Scope:            cred.projectScope(),
+		}
+		err := cred.setUser(&opts)
+		if err != nil {
+			return gophercloud.AuthOptions{}, err
+		}
+		return opts, nil
+	default:
+		return gophercloud.AuthOptions{}, fmt.Errorf("unknown auth method %s", method)
+	}
+}
+
+// setUser sets the user (and user domain) in the options, user ID takes priority over username.
+func (cred CredentialEnv) setUser(opts *gophercloud.AuthOptions) error {
+	if cred.UserID != "" {
+		opts.UserID = cred.UserID
+		return nil
+	}
+	if cred.Username == "" {
+		return fmt.Errorf("username or user ID is missing, set user_name/user_id in provider or OS_USERNAME/OS_USER_ID")
+	}
+	opts.Username = cred.Username
+	// domain ID takes priority over domain name, gophercloud rejects having both
+	if cred.UserDomainID != "" {
+		opts.DomainID = cred.UserDomainID
+	} else if cred.UserDomainName != "" {
+		opts.DomainName = cred.UserDomainName
+	} else {
+		return fmt.Errorf("user domain is required with username, set user_domain_name/user_domain_id in provider or OS_USER_DOMAIN_NAME/OS_USER_DOMAIN_ID")
+	}
+	return nil
+}
+
+// projectScope returns the project scope for password and token authentication, project domain defaults to user domain.
+// nil is returned if no project is specified, which results in an unscoped token.
+func (cred CredentialEnv) projectScope() *gophercloud.AuthScope {
+	if cred.ProjectID != "" {
+		return &gophercloud.AuthScope{ProjectID: cred.ProjectID}
+	}
+	if cred.ProjectName == "" {
+		return nil
+	}
+	scope := &gophercloud.AuthScope{ProjectName: cred.ProjectName}
+	switch {
+	case cred.ProjectDomainID != "":
+		scope.DomainID = cred.ProjectDomainID
+	case cred.ProjectDomainName != "":
+		scope.DomainName = cred.ProjectDomainName
+	case cred.UserDomainID != "":
+		scope.DomainID = cred.UserDomainID
+	default:
+		scope.DomainName = cred.UserDomainName
+	}
+	return scope
 }
 
 // TokenMetadata is the data returned in HTTP response body when obtaining token
 type TokenMetadata struct {
-	IsDomain                        bool                   `json:"is_domain"`
-	Methods                         []string               `json:"methods"`
-	Roles                           []TokenMetadataRole    `json:"roles"`
-	ExpiresAt                       time.Time              `json:"expires_at"`
-	Project                         TokenMetadataProject   `json:"project"`
-	Catalog                         []TokenMetadataCatalog `json:"catalog"`
-	ApplicationCredentialRestricted bool                   `json:"application_credential_restricted"`
-	User                            TokenMetadataUser      `json:"user"`
-	AuditIDs                        []string               `json:"audit_ids"`
-	IssuedAt                        time.Time              `json:"issued_at"`
+	IsDomain                        bool                 `json:"is_domain"`
+	Methods                         []string             `json:"methods"`
+	Roles                           []TokenMetadataRole  `json:"roles"`
+	ExpiresAt                       time.Time            `json:"expires_at"`
+	Project                         TokenMetadataProject `json:"project"`
+	Catalog                         []CatalogEntry       `json:"catalog"`
+	ApplicationCredentialRestricted bool                 `json:"application_credential_restricted"`
+	User                            TokenMetadataUser    `json:"user"`
+	AuditIDs                        []string             `json:"audit_ids"`
+	IssuedAt                        time.Time            `json:"issued_at"`
 }
 
 type TokenMetadataRole struct {
@@ -43,19 +258,6 @@ type TokenMetadataProject struct {
 	Name   string `json:"name"`
 }
 
-type TokenMetadataCatalog struct {
-	Endpoints []struct {
-		RegionID  string `json:"region_id"`
-		URL       string `json:"url"`
-		Region    string `json:"region"`
-		Interface string `json:"interface"`
-		ID        string `json:"id"`
-	} `json:"endpoints"`
-	Type string `json:"type"`
-	ID   string `json:"id"`
-	Name string `json:"name"`
-}
-
 type TokenMetadataUser struct {
 	PasswordExpiresAt interface{} `json:"password_expires_at"`
 	Domain            Domain      `json:"domain"`
@@ -97,15 +299,21 @@ func authResultToTokenMetadata(authResult gophercloud.AuthResult, client *gopher
 
 // implement by tokensv3.CreateResult and tokensv3.GetResult
 type iAuthResult interface {
+	ExtractToken() (*tokensv3.Token, error)
 	ExtractTokenID() (string, error)
 	ExtractServiceCatalog() (*tokensv3.ServiceCatalog, error)
 	ExtractUser() (*tokensv3.User, error)
 	ExtractRoles() ([]tokensv3.Role, error)
 	ExtractProject() (*tokensv3.Project, error)
 	ExtractDomain() (*tokensv3.Domain, error)
+	ExtractIntoStructPtr(to interface{}, label string) error
 }
 
 func extractTokenMetadataFromAuthResult(result iAuthResult) (TokenMetadata, error) {
+	token, err := result.ExtractToken()
+	if err != nil {
+		return TokenMetadata{}, err
+	}
 	user, err := result.ExtractUser()
 	if err != nil {
 		return TokenMetadata{}, err
@@ -114,8 +322,24 @@ func extractTokenMetadataFromAuthResult(result iAuthResult) (TokenMetadata, erro
 	if err != nil {
 		return TokenMetadata{}, err
 	}
+	if project == nil {
+		// unscoped token
+		project = &tokensv3.Project{}
+	}
+	serviceCatalog, err := result.ExtractServiceCatalog()
+	if err != nil {
+		return TokenMetadata{}, err
+	}
+	// gophercloud does not extract issued_at, it is only used to tell the lifetime of token,
+	// so it is left zero if absent or malformed
+	var issued struct {
+		IssuedAt time.Time `json:"issued_at"`
+	}
+	_ = result.ExtractIntoStructPtr(&issued, "token")
 	return TokenMetadata{
-		Roles: nil,
+		IssuedAt:  issued.IssuedAt,
+		ExpiresAt: token.ExpiresAt,
+		Roles:     nil,
 		Project: TokenMetadataProject{
 			Domain: Domain{
 				ID:   project.Domain.ID,
@@ -124,6 +348,7 @@ func extractTokenMetadataFromAuthResult(result iAuthResult) (TokenMetadata, erro
 			ID:   project.ID,
 			Name: project.Name,
 		},
+		Catalog:                         catalogFromServiceCatalog(serviceCatalog),
 		ApplicationCredentialRestricted: false,
 		User: TokenMetadataUser{
 			PasswordExpiresAt: nil,
@@ -136,3 +361,30 @@ func extractTokenMetadataFromAuthResult(result iAuthResult) (TokenMetadata, erro
 		},
 	}, nil
 }
+
+// catalogFromServiceCatalog converts the catalog embedded in the token response
+func catalogFromServiceCatalog(serviceCatalog *tokensv3.ServiceCatalog) []CatalogEntry {
+	if serviceCatalog == nil {
+		return nil
+	}
+	entries := make([]CatalogEntry, 0, len(serviceCatalog.Entries))
+	for _, entry := range serviceCatalog.Entries {
+		endpoints := make([]CatalogEndpoint, 0, len(entry.Endpoints))
+		for _, endpoint := range entry.Endpoints {
+			endpoints = append(endpoints, CatalogEndpoint{
+				ID:        endpoint.ID,
+				Interface: endpoint.Interface,
+				Region:    endpoint.Region,
+				RegionID:  endpoint.RegionID,
+				URL:       endpoint.URL,
+			})
+		}
+		entries = append(entries, CatalogEntry{
+			Endpoints: endpoints,
+			ID:        entry.ID,
+			Type:      entry.Type,
+			Name:      entry.Name,
+		})
+	}
+	return entries
+}
@@ -0,0 +1,170 @@
+package openstack
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// cloudsYAML is the content of clouds.yaml (and secure.yaml)
+// https://docs.openstack.org/python-openstackclient/latest/configuration/index.html#clouds-yaml
+type cloudsYAML struct {
+	Clouds map[string]cloudYAML `yaml:"clouds"`
+}
+
+// cloudYAML is a single cloud entry in clouds.yaml
+type cloudYAML struct {
+	Auth               cloudAuthYAML `yaml:"auth"`
+	AuthType           string        `yaml:"auth_type"`
+	RegionName         string        `yaml:"region_name"`
+	Interface          string        `yaml:"interface"`
+	IdentityAPIVersion string        `yaml:"identity_api_version"`
+	CACertFile         string        `yaml:"cacert"`
+	Cert               string        `yaml:"cert"`
+	Key                string        `yaml:"key"`
+	Verify             *bool         `yaml:"verify"`
+}
+
+type cloudAuthYAML struct {
+	AuthURL                     string `yaml:"auth_url"`
+	Token                       string `yaml:"token"`
+	Username                    string `yaml:"username"`
+	UserID                      string `yaml:"user_id"`
+	Password                    string `yaml:"password"`
+	UserDomainName              string `yaml:"user_domain_name"`
+	UserDomainID                string `yaml:"user_domain_id"`
+	ProjectID                   string `yaml:"project_id"`
+	ProjectName                 string `yaml:"project_name"`
+	ProjectDomainName           string `yaml:"project_domain_name"`
+	ProjectDomainID             string `yaml:"project_domain_id"`
+	ApplicationCredentialID     string `yaml:"application_credential_id"`
+	ApplicationCredentialName   string `yaml:"application_credential_name"`
+	ApplicationCredentialSecret string `yaml:"application_credential_secret"`
+}
+
+// LoadCloudCredential resolves a named cloud from clouds.yaml, secrets from secure.yaml (if exists) are merged into it.
+func LoadCloudCredential(cloudName string) (CredentialEnv, error) {
+	cloudsFile, err := findCloudConfigFile("OS_CLIENT_CONFIG_FILE", "clouds.yaml")
+	if err != nil {
+		return CredentialEnv{}, err
+	}
+	if cloudsFile == "" {
+		return CredentialEnv{}, fmt.Errorf("clouds.yaml not found, cannot resolve cloud %s", cloudName)
+	}
+	merged, err := readYAMLMap(cloudsFile)
+	if err != nil {
+		return CredentialEnv{}, err
+	}
+	secureFile, err := findCloudConfigFile("OS_CLIENT_SECURE_FILE", "secure.yaml")
+	if err != nil {
+		return CredentialEnv{}, err
+	}
+	if secureFile != "" {
+		secure, err := readYAMLMap(secureFile)
+		if err != nil {
+			return CredentialEnv{}, err
+		}
+		mergeYAMLMap(merged, secure)
+	}
+
+	// round trip the merged map to decode into the typed struct
+	raw, err := yaml.Marshal(merged)
+	if err != nil {
+		return CredentialEnv{}, err
+	}
+	var clouds cloudsYAML
+	err = yaml.Unmarshal(raw, &clouds)
+	if err != nil {
+		return CredentialEnv{}, err
+	}
+	cloud, ok := clouds.Clouds[cloudName]
+	if !ok {
+		return CredentialEnv{}, fmt.Errorf("cloud %s not found in %s", cloudName, cloudsFile)
+	}
+	return cloud.credential(), nil
+}
+
+func (cloud cloudYAML) credential() CredentialEnv {
+	cred := CredentialEnv{
+		RegionName:                  cloud.RegionName,
+		Interface:                   cloud.Interface,
+		AuthURL:                     cloud.Auth.AuthURL,
+		ApplicationCredentialSecret: cloud.Auth.ApplicationCredentialSecret,
+		ApplicationCredentialID:     cloud.Auth.ApplicationCredentialID,
+		ApplicationCredentialName:   cloud.Auth.ApplicationCredentialName,
+		AuthType:                    cloud.AuthType,
+		IdentityAPIVersion:          cloud.IdentityAPIVersion,
+		Token:                       cloud.Auth.Token,
+		Username:                    cloud.Auth.Username,
+		UserID:                      cloud.Auth.UserID,
+		Password:                    cloud.Auth.Password,
+		UserDomainName:              cloud.Auth.UserDomainName,
+		UserDomainID:                cloud.Auth.UserDomainID,
+		ProjectID:                   cloud.Auth.ProjectID,
+		ProjectName:                 cloud.Auth.ProjectName,
+		ProjectDomainName:           cloud.Auth.ProjectDomainName,
+		ProjectDomainID:             cloud.Auth.ProjectDomainID,
+		CACertFile:                  cloud.CACertFile,
+		Cert:                        cloud.Cert,
+		Key:                         cloud.Key,
+		Insecure:                    cloud.Verify != nil && !*cloud.Verify,
+	}
+	if cred.Interface == "" {
+		cred.Interface = "public"
+	}
+	return cred
+}
+
+// findCloudConfigFile looks for the config file in the same locations as openstack client does:
+// - path in the environment variable
+// - current directory
+// - ~/.config/openstack/
+// - /etc/openstack/
+// Empty string is returned if the file is not found in any of the locations.
+func findCloudConfigFile(envVar, filename string) (string, error) {
+	if path := os.Getenv(envVar); path != "" {
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("%s is set to %s, but the file is not accessible, %w", envVar, path, err)
+		}
+		return path, nil
+	}
+	candidates := []string{filename}
+	if home, err := os.UserHomeDir(); err == nil {
+		candidates = append(candidates, filepath.Join(home, ".config", "openstack", filename))
+	}
+	candidates = append(candidates, filepath.Join("/etc", "openstack", filename))
+	for _, path := range candidates {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", nil
+}
+
+func readYAMLMap(path string) (map[string]interface{}, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]interface{})
+	err = yaml.Unmarshal(content, &result)
+	if err != nil {
+		return nil, fmt.Errorf("fail to parse %s, %w", path, err)
+	}
+	return result, nil
+}
+
+// mergeYAMLMap merges src into dst recursively, values in src take priority
+func mergeYAMLMap(dst, src map[string]interface{}) {
+	for key, srcValue := range src {
+		srcMap, srcIsMap := srcValue.(map[string]interface{})
+		dstMap, dstIsMap := dst[key].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			mergeYAMLMap(dstMap, srcMap)
+			continue
+		}
+		dst[key] = srcValue
+	}
+}
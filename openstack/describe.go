@@ -0,0 +1,82 @@
+package openstack
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DescribeError gives an error a summary, and for errors from OpenStack API, a detail with the request ID and a remediation hint.
+// It is shared by the diagnostics of the provider and the CLI, so that both report errors the same way.
+// Other errors are summarized by their message, with empty detail.
+func DescribeError(err error) (summary string, detail string) {
+	var (
+		prerequisiteErr AutoAllocationPrerequisiteError
+		extensionErr    ExtensionMissingError
+		quotaErr        QuotaExceededError
+		forbiddenErr    ForbiddenError
+		conflictErr     ConflictError
+		notFoundErr     NotFoundError
+	)
+	switch {
+	case errors.As(err, &prerequisiteErr):
+		return "auto allocated topology prerequisites are not met", ErrorDetail(
+			fmt.Sprintf("%s: %s", prerequisiteErr.target(), prerequisiteErr.Reason),
+			prerequisiteErr.RequestID,
+			PrerequisiteHint(prerequisiteErr.Reason),
+		)
+	case errors.As(err, &extensionErr):
+		return fmt.Sprintf("Neutron extension %s is not enabled", extensionErr.Extension), ErrorDetail(
+			extensionErr.APIError.Error(),
+			extensionErr.RequestID,
+			"ask your admin to enable the auto_allocate service plugin in Neutron",
+		)
+	case errors.As(err, &quotaErr):
+		return "quota exceeded", ErrorDetail(
+			quotaErr.Error(),
+			quotaErr.RequestID,
+			"ask your admin to raise the network, subnet or router quota of the project, or remove unused resources",
+		)
+	case errors.As(err, &forbiddenErr):
+		return "permission denied", ErrorDetail(
+			forbiddenErr.Error(),
+			forbiddenErr.RequestID,
+			"check the roles of the credential, managing the topology of another project usually requires admin role",
+		)
+	case errors.As(err, &conflictErr):
+		return "conflict", ErrorDetail(
+			conflictErr.Error(),
+			conflictErr.RequestID,
+			"the resource is being modified by another operation, retry after it completes",
+		)
+	case errors.As(err, &notFoundErr):
+		return "not found", ErrorDetail(
+			notFoundErr.Error(),
+			notFoundErr.RequestID,
+			"the resource does not exist, or is not visible to the credential",
+		)
+	}
+	return err.Error(), ""
+}
+
+// PrerequisiteHint suggests how to fix the missing prerequisite based on the reason reported by Neutron
+func PrerequisiteHint(reason string) string {
+	lowerReason := strings.ToLower(reason)
+	switch {
+	case strings.Contains(lowerReason, "external network"):
+		return "no default external network is configured; ask your admin to set is_default on the external network (openstack network set --default)"
+	case strings.Contains(lowerReason, "subnetpool"), strings.Contains(lowerReason, "subnet pool"):
+		return "no default subnet pool is configured; ask your admin to create a shared default subnet pool (openstack subnet pool create --share --default)"
+	}
+	return "ask your admin to configure a default external network and default subnet pools"
+}
+
+// ErrorDetail joins the message of an error with the request ID (if any) and the hint, one per line
+func ErrorDetail(message, requestID, hint string) string {
+	lines := []string{message}
+	if requestID != "" {
+		lines = append(lines, fmt.Sprintf("request ID: %s", requestID))
+	}
+	lines = append(lines, fmt.Sprintf("hint: %s", hint))
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,311 @@
+package openstack
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+// networkAPIVersion is the version of Network API that NetworkClient speaks
+const networkAPIVersion = "v2.0"
+
+// serviceTypeAliases are the historical service types that are still found in some catalogs,
+// see https://service-types.openstack.org/
+var serviceTypeAliases = map[string][]string{
+	"network":  {"networking"},
+	"identity": {"identityv3"},
+	"compute":  {"computev21"},
+}
+
+// EndpointConfig controls how the endpoint of a service is selected, services are identified by their service type (e.g. network).
+type EndpointConfig struct {
+	Overrides  map[string]string // URL to use instead of the one in catalog, regardless of region
+	Interfaces map[string]string // interface (public, internal, admin) to select from catalog, defaults to the interface in credential
+}
+
+// endpointOverride returns the URL that overrides the catalog for a service type (or its aliases)
+func (c *Client) endpointOverride(serviceType string) (string, bool) {
+	candidates := append([]string{serviceType}, serviceTypeAliases[serviceType]...)
+	for _, candidate := range candidates {
+		if override, ok := c.endpointConfig.Overrides[candidate]; ok && override != "" {
+			return override, true
+		}
+	}
+	return "", false
+}
+
+// endpointInterface returns the interface to select from catalog for a service type
+func (c *Client) endpointInterface(serviceType string) string {
+	if interfaceName, ok := c.endpointConfig.Interfaces[serviceType]; ok && interfaceName != "" {
+		return interfaceName
+	}
+	return c.credEnv.Interface
+}
+
+// resolveNetworkURL returns the versioned base URL of Network API for a region,
+// from the endpoint override if there is one, otherwise from the catalog.
+func (c *Client) resolveNetworkURL(ctx context.Context, regionName string) (string, error) {
+	if c.provider == nil {
+		return "", fmt.Errorf("not authenticated")
+	}
+	endpointURL, ok := c.endpointOverride("network")
+	if ok {
+		tflog.Debug(ctx, "using endpoint override", map[string]interface{}{"service_type": "network", "url": endpointURL})
+	} else {
+		if regionName == "" {
+			regionName = c.credEnv.RegionName
+		}
+		entry, err := c.endpoint(ctx, "network", regionName, c.endpointInterface("network"))
+		if err != nil {
+			return "", err
+		}
+		endpointURL = entry.URL
+	}
+	return c.networkBaseURL(ctx, endpointURL)
+}
+
+// resolveIdentityURL returns the base URL of Identity API, from the endpoint override if there is one,
+// otherwise from the catalog if an interface is set for identity in EndpointConfig.
+// Empty means the auth URL that the token is issued by.
+func (c *Client) resolveIdentityURL(ctx context.Context) (string, error) {
+	endpointURL, ok := c.endpointOverride("identity")
+	if ok {
+		tflog.Debug(ctx, "using endpoint override", map[string]interface{}{"service_type": "identity", "url": endpointURL})
+		return endpointURL, nil
+	}
+	interfaceName, ok := c.endpointConfig.Interfaces["identity"]
+	if !ok || interfaceName == "" {
+		return "", nil
+	}
+	entry, err := c.endpoint(ctx, "identity", c.credEnv.RegionName, interfaceName)
+	if err != nil {
+		return "", err
+	}
+	return entry.URL, nil
+}
+
+// endpointKey identifies an endpoint resolved from the catalog
+type endpointKey struct {
+	serviceType   string
+	regionName    string
+	interfaceName string
+}
+
+// endpoint looks up the endpoint of a service in the catalog of the current token.
+// The result is cached, so that all resources in the same run share the same resolution.
+func (c *Client) endpoint(ctx context.Context, serviceType, regionName, interfaceName string) (CatalogEndpoint, error) {
+	key := endpointKey{serviceType: serviceType, regionName: regionName, interfaceName: interfaceName}
+	c.tokenLock.RLock()
+	endpoint, ok := c.endpointCache[key]
+	catalogEntries := c.tokenMetadata.Catalog
+	c.tokenLock.RUnlock()
+	if ok {
+		return endpoint, nil
+	}
+	logFields := map[string]interface{}{
+		"service_type": serviceType,
+		"region":       regionName,
+		"interface":    interfaceName,
+	}
+	if len(catalogEntries) == 0 {
+		tflog.Debug(ctx, "fail to resolve endpoint from catalog, catalog is empty", logFields)
+		return CatalogEndpoint{}, fmt.Errorf("catalog is empty")
+	}
+	endpoint, err := findEndpoint(catalogEntries, serviceType, regionName, interfaceName)
+	if err != nil {
+		logFields["error"] = err.Error()
+		tflog.Debug(ctx, "fail to resolve endpoint from catalog", logFields)
+		return CatalogEndpoint{}, err
+	}
+	logFields["url"] = endpoint.URL
+	tflog.Debug(ctx, "resolved endpoint from catalog", logFields)
+
+	c.tokenLock.Lock()
+	defer c.tokenLock.Unlock()
+	if c.endpointCache == nil {
+		c.endpointCache = make(map[endpointKey]CatalogEndpoint)
+	}
+	c.endpointCache[key] = endpoint
+	return endpoint, nil
+}
+
+// findEndpoint looks up the endpoint of a service type (or its aliases) in the catalog.
+// Region is matched against both region_id and the deprecated region field.
+// Empty regionName is only allowed if the service has endpoints in a single region, so that a region is not picked arbitrarily.
+// Interface defaults to public if empty.
+func findEndpoint(catalogEntries []CatalogEntry, serviceType, regionName, interfaceName string) (CatalogEndpoint, error) {
+	if interfaceName == "" {
+		interfaceName = "public"
+	}
+	serviceEntry, ok := findCatalogEntry(catalogEntries, serviceType)
+	if !ok {
+		return CatalogEndpoint{}, fmt.Errorf("service type %s not found in catalog", serviceType)
+	}
+	if regionName == "" {
+		return findEndpointAnyRegion(serviceEntry, serviceType, interfaceName)
+	}
+	for _, endpoint := range serviceEntry.Endpoints {
+		if endpoint.Interface != interfaceName {
+			continue
+		}
+		if endpoint.RegionID == regionName || endpoint.Region == regionName {
+			return endpoint, nil
+		}
+	}
+	return CatalogEndpoint{}, fmt.Errorf("service catalog for %s (%s) does not have %s endpoint for region %s", serviceEntry.Name, serviceType, interfaceName, regionName)
+}
+
+// findEndpointAnyRegion looks up the endpoint of a service when region is not configured,
+// it fails if the service has endpoints in more than one region.
+func findEndpointAnyRegion(serviceEntry CatalogEntry, serviceType, interfaceName string) (CatalogEndpoint, error) {
+	var found []CatalogEndpoint
+	var regions []string
+	for _, endpoint := range serviceEntry.Endpoints {
+		if endpoint.Interface != interfaceName {
+			continue
+		}
+		region := endpoint.RegionID
+		if region == "" {
+			region = endpoint.Region
+		}
+		if !containsString(regions, region) {
+			regions = append(regions, region)
+		}
+		found = append(found, endpoint)
+	}
+	if len(found) == 0 {
+		return CatalogEndpoint{}, fmt.Errorf("service catalog for %s (%s) does not have %s endpoint", serviceEntry.Name, serviceType, interfaceName)
+	}
+	if len(regions) > 1 {
+		return CatalogEndpoint{}, fmt.Errorf("service catalog for %s (%s) has %s endpoints in more than one region %v, region must be configured", serviceEntry.Name, serviceType, interfaceName, regions)
+	}
+	return found[0], nil
+}
+
+// findCatalogEntry looks up the catalog entry of a service type, the official service type takes priority over its aliases.
+func findCatalogEntry(catalogEntries []CatalogEntry, serviceType string) (CatalogEntry, bool) {
+	candidates := append([]string{serviceType}, serviceTypeAliases[serviceType]...)
+	for _, candidate := range candidates {
+		for _, entry := range catalogEntries {
+			if entry.Type == candidate {
+				return entry, true
+			}
+		}
+	}
+	return CatalogEntry{}, false
+}
+
+// networkBaseURL returns the versioned base URL of Network API from the endpoint URL in catalog.
+// Catalog could list Neutron with or without the version in the URL, if the version is absent
+// it is discovered from the version document at the root of the endpoint.
+// The result is cached per endpoint URL, and concurrent callers for the same endpoint share one discovery,
+// the lock is not held during the discovery request so that callers for other endpoints are not blocked.
+func (c *Client) networkBaseURL(ctx context.Context, endpointURL string) (string, error) {
+	endpointURL = strings.TrimSuffix(endpointURL, "/")
+	if strings.HasSuffix(endpointURL, "/"+networkAPIVersion) {
+		return endpointURL, nil
+	}
+
+	for {
+		c.discoveryLock.Lock()
+		if baseURL, ok := c.discoveredURLs[endpointURL]; ok {
+			c.discoveryLock.Unlock()
+			return baseURL, nil
+		}
+		call, inFlight := c.discoveries[endpointURL]
+		if !inFlight {
+			call = &discoveryCall{done: make(chan struct{})}
+			if c.discoveries == nil {
+				c.discoveries = make(map[string]*discoveryCall)
+			}
+			c.discoveries[endpointURL] = call
+			c.discoveryLock.Unlock()
+			return c.runDiscovery(ctx, endpointURL, call)
+		}
+		c.discoveryLock.Unlock()
+
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+		// discovery that is canceled by the context of another caller is retried with own context
+		if errors.Is(call.err, context.Canceled) || errors.Is(call.err, context.DeadlineExceeded) {
+			continue
+		}
+		return call.baseURL, call.err
+	}
+}
+
+// discoveryCall is a version discovery in flight, done is closed when baseURL and err are set
+type discoveryCall struct {
+	done    chan struct{}
+	baseURL string
+	err     error
+}
+
+// runDiscovery discovers the version for endpointURL, caches the result and notifies callers waiting on call
+func (c *Client) runDiscovery(ctx context.Context, endpointURL string, call *discoveryCall) (string, error) {
+	baseURL, err := c.discoverNetworkVersion(ctx, endpointURL)
+
+	c.discoveryLock.Lock()
+	delete(c.discoveries, endpointURL)
+	if err == nil {
+		tflog.Debug(ctx, "discovered network API version", map[string]interface{}{"endpoint_url": endpointURL, "base_url": baseURL})
+		if c.discoveredURLs == nil {
+			c.discoveredURLs = make(map[string]string)
+		}
+		c.discoveredURLs[endpointURL] = baseURL
+	}
+	c.discoveryLock.Unlock()
+
+	call.baseURL, call.err = baseURL, err
+	close(call.done)
+	if err != nil {
+		return "", err
+	}
+	return baseURL, nil
+}
+
+// discoverNetworkVersion checks the version document at the root of Network API to see if v2.0 is supported.
+// The version is appended to the endpoint URL rather than using the link in the version document,
+// since the link is often generated with the internal host name when Neutron is behind a proxy.
+// If the version document is not available, v2.0 is assumed.
+// https://docs.openstack.org/api-ref/network/v2/index.html#list-api-versions
+func (c *Client) discoverNetworkVersion(ctx context.Context, endpointURL string) (string, error) {
+	fallbackURL := endpointURL + "/" + networkAPIVersion
+
+	token, err := c.currentToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	resp, err := makeRequest(ctx, c.httpClient, c.retryPolicy, c.userAgent, http.MethodGet, endpointURL+"/", token, nil, []int{http.StatusOK, http.StatusMultipleChoices})
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	if err != nil {
+		return fallbackURL, nil
+	}
+	defer resp.Body.Close()
+	var respBody struct {
+		Versions []struct {
+			ID     string `json:"id"`
+			Status string `json:"status"`
+		} `json:"versions"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&respBody)
+	if err != nil || len(respBody.Versions) == 0 {
+		return fallbackURL, nil
+	}
+	for _, version := range respBody.Versions {
+		if version.ID == networkAPIVersion {
+			return fallbackURL, nil
+		}
+	}
+	return "", fmt.Errorf("network API %s is not supported by %s", networkAPIVersion, endpointURL)
+}
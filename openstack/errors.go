@@ -0,0 +1,155 @@
+package openstack
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gophercloud/gophercloud"
+)
+
+// APIError is an error response from OpenStack API
+type APIError struct {
+	StatusCode int
+	Status     string
+	Method     string
+	URL        string
+	RequestID  string // X-Openstack-Request-Id, for operators to look up the request in the logs
+	Type       string // type of NeutronError, e.g. NetworkNotFound, OverQuota
+	Message    string
+}
+
+// Error ...
+func (e APIError) Error() string {
+	message := e.Message
+	if e.Type != "" {
+		message = fmt.Sprintf("%s: %s", e.Type, e.Message)
+	}
+	if e.RequestID == "" {
+		return fmt.Sprintf("%s, %s %s, %s", e.Status, e.Method, e.URL, message)
+	}
+	return fmt.Sprintf("%s, %s %s, %s (request ID %s)", e.Status, e.Method, e.URL, message, e.RequestID)
+}
+
+// NotFoundError is the error when the requested entity does not exist (404)
+type NotFoundError struct{ APIError }
+
+// ConflictError is the error when the request conflicts with the current state (409)
+type ConflictError struct{ APIError }
+
+// ForbiddenError is the error when the credential is not allowed to perform the request (403)
+type ForbiddenError struct{ APIError }
+
+// QuotaExceededError is the error when the request exceeds the quota of the project
+type QuotaExceededError struct{ APIError }
+
+// ExtensionMissingError is the error when the API extension needed by the request is not enabled
+type ExtensionMissingError struct {
+	APIError
+	Extension string // alias of the extension, e.g. auto-allocated-topology
+}
+
+// Error ...
+func (e ExtensionMissingError) Error() string {
+	return fmt.Sprintf("extension %s is not enabled, %s", e.Extension, e.APIError.Error())
+}
+
+// newAPIError creates a typed error from the error response, the body is either a NeutronError or a Keystone error.
+// https://docs.openstack.org/api-ref/network/v2/index.html#response-codes
+func newAPIError(resp *http.Response, method, url string, body []byte) error {
+	apiErr := APIError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Method:     method,
+		URL:        url,
+		RequestID:  requestIDFromHeader(resp.Header),
+	}
+	var errBody struct {
+		NeutronError *struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+			Detail  string `json:"detail"`
+		} `json:"NeutronError"`
+		Error *struct {
+			Code    int    `json:"code"`
+			Title   string `json:"title"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	err := json.Unmarshal(body, &errBody)
+	switch {
+	case err == nil && errBody.NeutronError != nil:
+		apiErr.Type = errBody.NeutronError.Type
+		apiErr.Message = errBody.NeutronError.Message
+	case err == nil && errBody.Error != nil:
+		apiErr.Message = errBody.Error.Message
+	default:
+		apiErr.Message = strings.TrimSpace(string(body))
+	}
+	return typedAPIError(apiErr)
+}
+
+// fromGophercloudError converts an error response from gophercloud into a typed error, other errors are returned as is
+func fromGophercloudError(err error) error {
+	var respErr gophercloud.ErrUnexpectedResponseCode
+	switch e := err.(type) {
+	case gophercloud.ErrDefault403:
+		respErr = e.ErrUnexpectedResponseCode
+	case gophercloud.ErrDefault404:
+		respErr = e.ErrUnexpectedResponseCode
+	case gophercloud.ErrDefault409:
+		respErr = e.ErrUnexpectedResponseCode
+	case gophercloud.ErrUnexpectedResponseCode:
+		respErr = e
+	default:
+		return err
+	}
+	resp := &http.Response{
+		StatusCode: respErr.Actual,
+		Status:     fmt.Sprintf("%d %s", respErr.Actual, http.StatusText(respErr.Actual)),
+		Header:     respErr.ResponseHeader,
+	}
+	return newAPIError(resp, respErr.Method, respErr.URL, respErr.Body)
+}
+
+func typedAPIError(apiErr APIError) error {
+	if apiErr.Type == "OverQuota" || apiErr.StatusCode == http.StatusRequestEntityTooLarge {
+		// Neutron reports exceeding quota as 409, some older services use 413
+		return QuotaExceededError{apiErr}
+	}
+	switch apiErr.StatusCode {
+	case http.StatusNotFound:
+		return NotFoundError{apiErr}
+	case http.StatusConflict:
+		return ConflictError{apiErr}
+	case http.StatusForbidden:
+		return ForbiddenError{apiErr}
+	}
+	return apiErr
+}
+
+// asExtensionMissing turns a 404 on the resource of an extension into ExtensionMissingError.
+// A 404 without a specific NeutronError type (e.g. NetworkNotFound) means the resource itself is unknown to Neutron,
+// i.e. the extension that provides the resource is not enabled.
+func asExtensionMissing(err error, extension string) error {
+	var notFound NotFoundError
+	if !errors.As(err, &notFound) {
+		return err
+	}
+	if notFound.Type != "" && notFound.Type != "HTTPNotFound" {
+		return err
+	}
+	return ExtensionMissingError{APIError: notFound.APIError, Extension: extension}
+}
+
+func requestIDFromHeader(header http.Header) string {
+	if header == nil {
+		return ""
+	}
+	if requestID := header.Get("X-Openstack-Request-Id"); requestID != "" {
+		return requestID
+	}
+	return header.Get("X-Compute-Request-Id")
+}
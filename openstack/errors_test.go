@@ -0,0 +1,26 @@
+package openstack
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestWrappedErrorMapping(t *testing.T) {
+	notFound := fmt.Errorf("wrapped, %w", NotFoundError{APIError{StatusCode: 404}})
+	var extensionErr ExtensionMissingError
+	if !errors.As(asExtensionMissing(notFound, "auto-allocated-topology"), &extensionErr) {
+		t.Errorf("expected ExtensionMissingError for wrapped 404")
+	}
+
+	conflict := fmt.Errorf("wrapped, %w", ConflictError{APIError{StatusCode: 409, Message: "No default router:external network.", RequestID: "req-1"}})
+	var prerequisiteErr AutoAllocationPrerequisiteError
+	if !errors.As(autoAllocationError(conflict, "project-1"), &prerequisiteErr) || prerequisiteErr.RequestID != "req-1" {
+		t.Errorf("expected AutoAllocationPrerequisiteError for wrapped 409, got %+v", prerequisiteErr)
+	}
+
+	typed := NotFoundError{APIError{StatusCode: 404, Type: "NetworkNotFound"}}
+	if !errors.As(asExtensionMissing(typed, "auto-allocated-topology"), &NotFoundError{}) {
+		t.Errorf("expected typed 404 to stay NotFoundError")
+	}
+}
@@ -0,0 +1,330 @@
+package fake
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type project struct {
+	ID   string
+	Name string
+}
+
+type fakeToken struct {
+	projectID string
+	methods   []string
+	issuedAt  time.Time
+	expiresAt time.Time
+}
+
+// issueToken must be called with lock held
+func (s *Server) issueToken(projectID string, methods []string) string {
+	id := newID()
+	now := time.Now().UTC()
+	s.tokens[id] = fakeToken{
+		projectID: projectID,
+		methods:   methods,
+		issuedAt:  now,
+		expiresAt: now.Add(s.tokenTTL),
+	}
+	return id
+}
+
+// validToken must be called with lock held
+func (s *Server) validToken(id string) (fakeToken, bool) {
+	token, ok := s.tokens[id]
+	if !ok || time.Now().After(token.expiresAt) {
+		return fakeToken{}, false
+	}
+	return token, true
+}
+
+// AddProject adds a project that the user is a member of
+func (s *Server) AddProject(id, name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.projects = append(s.projects, project{ID: id, Name: name})
+}
+
+// https://docs.openstack.org/api-ref/identity/v3/index.html#password-authentication-with-scoped-authorization
+type authRequest struct {
+	Auth struct {
+		Identity struct {
+			Methods  []string `json:"methods"`
+			Password *struct {
+				User authUser `json:"user"`
+			} `json:"password"`
+			ApplicationCredential *struct {
+				ID     string    `json:"id"`
+				Name   string    `json:"name"`
+				Secret string    `json:"secret"`
+				User   *authUser `json:"user"`
+			} `json:"application_credential"`
+			Token *struct {
+				ID string `json:"id"`
+			} `json:"token"`
+		} `json:"identity"`
+		Scope *struct {
+			Project *struct {
+				ID     string `json:"id"`
+				Name   string `json:"name"`
+				Domain *struct {
+					ID   string `json:"id"`
+					Name string `json:"name"`
+				} `json:"domain"`
+			} `json:"project"`
+		} `json:"scope"`
+	} `json:"auth"`
+}
+
+type authUser struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Password string `json:"password"`
+	Domain   *struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"domain"`
+}
+
+func (u authUser) matches() bool {
+	if u.ID != "" {
+		return u.ID == UserID
+	}
+	if u.Name != Username || u.Domain == nil {
+		return false
+	}
+	return u.Domain.ID == DomainID || u.Domain.Name == DomainName
+}
+
+func (s *Server) handleTokens(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		s.createToken(w, r)
+	case http.MethodGet:
+		s.lock.Lock()
+		_, callerOK := s.validToken(r.Header.Get("X-Auth-Token"))
+		subjectID := r.Header.Get("X-Subject-Token")
+		subject, subjectOK := s.validToken(subjectID)
+		s.lock.Unlock()
+		if !callerOK {
+			writeKeystoneError(w, http.StatusUnauthorized, "The request you have made requires authentication.")
+			return
+		}
+		if !subjectOK {
+			writeKeystoneError(w, http.StatusNotFound, "Could not find token.")
+			return
+		}
+		s.writeToken(w, http.StatusOK, subjectID, subject)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) createToken(w http.ResponseWriter, r *http.Request) {
+	var req authRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeKeystoneError(w, http.StatusBadRequest, "malformed request body")
+		return
+	}
+	identity := req.Auth.Identity
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	projectID := ProjectID
+	switch {
+	case identity.ApplicationCredential != nil:
+		appCred := identity.ApplicationCredential
+		idOK := appCred.ID == ApplicationCredentialID
+		nameOK := appCred.Name == ApplicationCredentialName && appCred.User != nil && appCred.User.matches()
+		if !(idOK || nameOK) || appCred.Secret != ApplicationCredentialSecret {
+			writeKeystoneError(w, http.StatusUnauthorized, "The request you have made requires authentication.")
+			return
+		}
+		// application credential is always scoped to its project
+	case identity.Password != nil:
+		if !identity.Password.User.matches() || identity.Password.User.Password != Password {
+			writeKeystoneError(w, http.StatusUnauthorized, "The request you have made requires authentication.")
+			return
+		}
+		// without scope, token is scoped to the default project of the user
+	case identity.Token != nil:
+		token, ok := s.validToken(identity.Token.ID)
+		if !ok {
+			writeKeystoneError(w, http.StatusUnauthorized, "The request you have made requires authentication.")
+			return
+		}
+		projectID = token.projectID
+	default:
+		writeKeystoneError(w, http.StatusBadRequest, "unsupported authentication method")
+		return
+	}
+
+	if req.Auth.Scope != nil && req.Auth.Scope.Project != nil && identity.ApplicationCredential == nil {
+		scope := req.Auth.Scope.Project
+		projectID = ""
+		for _, p := range s.projects {
+			if p.ID == scope.ID || (scope.ID == "" && p.Name == scope.Name) {
+				projectID = p.ID
+				break
+			}
+		}
+		if projectID == "" {
+			writeKeystoneError(w, http.StatusUnauthorized, "User has no access to project")
+			return
+		}
+	}
+
+	id := s.issueToken(projectID, identity.Methods)
+	s.writeTokenLocked(w, http.StatusCreated, id, s.tokens[id])
+}
+
+func (s *Server) writeToken(w http.ResponseWriter, status int, id string, token fakeToken) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.writeTokenLocked(w, status, id, token)
+}
+
+// writeTokenLocked must be called with lock held
+func (s *Server) writeTokenLocked(w http.ResponseWriter, status int, id string, token fakeToken) {
+	domain := map[string]string{"id": DomainID, "name": DomainName}
+	body := map[string]interface{}{
+		"methods":    token.methods,
+		"issued_at":  token.issuedAt.Format(time.RFC3339Nano),
+		"expires_at": token.expiresAt.Format(time.RFC3339Nano),
+		"user": map[string]interface{}{
+			"id":     UserID,
+			"name":   Username,
+			"domain": domain,
+		},
+		"roles": []map[string]string{{"id": "fake-role-id", "name": "member"}},
+	}
+	if token.projectID != "" {
+		body["project"] = map[string]interface{}{
+			"id":     token.projectID,
+			"name":   s.projectName(token.projectID),
+			"domain": domain,
+		}
+		body["catalog"] = s.catalog()
+	}
+	w.Header().Set("X-Subject-Token", id)
+	writeJSON(w, status, map[string]interface{}{"token": body})
+}
+
+// projectName must be called with lock held
+func (s *Server) projectName(id string) string {
+	for _, p := range s.projects {
+		if p.ID == id {
+			return p.Name
+		}
+	}
+	return ""
+}
+
+// NetworkCatalog controls how Neutron is listed in the catalog, to simulate the variants found in real clouds
+type NetworkCatalog struct {
+	ServiceType  string   // service type of Neutron, defaults to network, e.g. networking for the historical alias
+	RegionIDOnly bool     // omit the deprecated region field, only region_id is set
+	VersionedURL bool     // list the URL with the API version, e.g. <NetworkURL>/v2.0
+	ExtraRegions []string // also list Neutron in these regions, with the same URL
+}
+
+// SetNetworkCatalog changes how Neutron is listed in the catalog of tokens issued afterwards
+func (s *Server) SetNetworkCatalog(networkCatalog NetworkCatalog) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.networkCatalog = networkCatalog
+}
+
+// catalog must be called with lock held
+func (s *Server) catalog() []map[string]interface{} {
+	endpoints := func(url, internalURL string, regions []string, regionIDOnly bool) []map[string]string {
+		var result []map[string]string
+		for _, region := range regions {
+			for _, iface := range []string{"public", "internal", "admin"} {
+				endpointURL := url
+				if iface == "internal" {
+					endpointURL = internalURL
+				}
+				endpoint := map[string]string{
+					"id":        newID(),
+					"interface": iface,
+					"region_id": region,
+					"url":       endpointURL,
+				}
+				if !regionIDOnly {
+					endpoint["region"] = region
+				}
+				result = append(result, endpoint)
+			}
+		}
+		return result
+	}
+	networkType := s.networkCatalog.ServiceType
+	if networkType == "" {
+		networkType = "network"
+	}
+	networkURL := s.NetworkURL()
+	if s.networkCatalog.VersionedURL {
+		networkURL += "/v2.0"
+	}
+	networkRegions := append([]string{RegionName}, s.networkCatalog.ExtraRegions...)
+	return []map[string]interface{}{
+		{
+			"id":        "fake-identity-service-id",
+			"type":      "identity",
+			"name":      "keystone",
+			"endpoints": endpoints(s.server.URL+"/identity", s.server.URL+"/internal/identity", []string{RegionName}, false),
+		},
+		{
+			"id":        "fake-network-service-id",
+			"type":      networkType,
+			"name":      "neutron",
+			"endpoints": endpoints(networkURL, networkURL, networkRegions, s.networkCatalog.RegionIDOnly),
+		},
+	}
+}
+
+// https://docs.openstack.org/api-ref/identity/v3/index.html#get-service-catalog
+func (s *Server) handleCatalog(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"catalog": s.catalog(),
+		"links":   map[string]interface{}{"self": r.URL.String(), "next": nil, "previous": nil},
+	})
+}
+
+// https://docs.openstack.org/api-ref/identity/v3/index.html#list-projects-for-user
+func (s *Server) handleUserProjects(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/identity/v3/users/"), "/")
+	if r.Method != http.MethodGet || len(parts) != 2 || parts[1] != "projects" {
+		writeKeystoneError(w, http.StatusNotFound, "The resource could not be found.")
+		return
+	}
+	if parts[0] != UserID {
+		writeKeystoneError(w, http.StatusNotFound, "Could not find user.")
+		return
+	}
+	s.lock.Lock()
+	var projects []map[string]interface{}
+	for _, p := range s.projects {
+		projects = append(projects, map[string]interface{}{
+			"id":        p.ID,
+			"name":      p.Name,
+			"domain_id": DomainID,
+			"enabled":   true,
+			"is_domain": false,
+			"links":     map[string]string{"self": s.server.URL + "/identity/v3/projects/" + p.ID},
+		})
+	}
+	s.lock.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"projects": projects,
+		"links":    map[string]interface{}{"self": r.URL.String(), "next": nil, "previous": nil},
+	})
+}
@@ -0,0 +1,381 @@
+package fake
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type network struct {
+	ID        string
+	Name      string
+	ProjectID string
+	MTU       int
+	External  bool
+	IsDefault bool
+}
+
+type subnet struct {
+	ID        string
+	NetworkID string
+	ProjectID string
+	CIDR      string
+	GatewayIP string
+	IPVersion int
+}
+
+type fixedIP struct {
+	SubnetID  string `json:"subnet_id"`
+	IPAddress string `json:"ip_address"`
+}
+
+type router struct {
+	ID                string
+	Name              string
+	ProjectID         string
+	ExternalNetworkID string
+	ExternalFixedIPs  []fixedIP
+}
+
+type port struct {
+	ID          string
+	NetworkID   string
+	DeviceID    string
+	DeviceOwner string
+	FixedIPs    []fixedIP
+}
+
+// SetDefaultExternalNetwork adds or removes the default external network, without it auto allocation fails with 409.
+// The server starts with the default external network.
+func (s *Server) SetDefaultExternalNetwork(exists bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !exists {
+		delete(s.networks, ExternalNetworkID)
+		delete(s.subnets, externalSubnetID)
+		return
+	}
+	s.networks[ExternalNetworkID] = &network{
+		ID:        ExternalNetworkID,
+		Name:      "public",
+		ProjectID: "fake-admin-project-id",
+		MTU:       1500,
+		External:  true,
+		IsDefault: true,
+	}
+	s.subnets[externalSubnetID] = &subnet{
+		ID:        externalSubnetID,
+		NetworkID: ExternalNetworkID,
+		ProjectID: "fake-admin-project-id",
+		CIDR:      "203.0.113.0/24",
+		GatewayIP: "203.0.113.1",
+		IPVersion: 4,
+	}
+}
+
+// SetAutoAllocation enables or disables the auto-allocated-topology extension, it is enabled by default
+func (s *Server) SetAutoAllocation(enabled bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.autoAllocationDisabled = !enabled
+}
+
+// TopologyNetworkID returns the network ID of the auto allocated topology of a project, empty if there is none
+func (s *Server) TopologyNetworkID(projectID string) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.topologies[projectID]
+}
+
+// https://docs.openstack.org/api-ref/network/v2/index.html#list-api-versions
+func (s *Server) handleNetworkVersions(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/network/" {
+		// resource of an extension that is not enabled
+		writeNeutronError(w, http.StatusNotFound, "HTTPNotFound", "The resource could not be found.")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"versions": []map[string]interface{}{
+			{
+				"id":     "v2.0",
+				"status": "CURRENT",
+				"links":  []map[string]string{{"href": s.NetworkURL() + "/v2.0/", "rel": "self"}},
+			},
+		},
+	})
+}
+
+// https://docs.openstack.org/api-ref/network/v2/index.html#auto-allocated-topology
+func (s *Server) handleAutoAllocatedTopology(w http.ResponseWriter, r *http.Request) {
+	projectID := strings.TrimPrefix(r.URL.Path, "/network/v2.0/auto-allocated-topology/")
+	if projectID == "" || strings.Contains(projectID, "/") {
+		writeNeutronError(w, http.StatusNotFound, "HTTPNotFound", "The resource could not be found.")
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.autoAllocationDisabled {
+		writeNeutronError(w, http.StatusNotFound, "HTTPNotFound", "The resource could not be found.")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		// as Neutron, dry-run checks the requirements before looking up the existing topology
+		if r.URL.Query().Get("fields") == "dry-run" {
+			if s.networks[ExternalNetworkID] == nil {
+				writeNeutronError(w, http.StatusConflict, "AutoAllocationFailure", "Deployment error: No default router:external network.")
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{"auto_allocated_topology": map[string]string{"dry-run": "pass"}})
+			return
+		}
+		if _, ok := s.topologies[projectID]; !ok && s.networks[ExternalNetworkID] == nil {
+			writeNeutronError(w, http.StatusConflict, "AutoAllocationFailure", "Deployment error: No default router:external network.")
+			return
+		}
+		networkID, ok := s.topologies[projectID]
+		if !ok {
+			networkID = s.allocateTopology(projectID)
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"auto_allocated_topology": map[string]string{
+				"id":         networkID,
+				"project_id": projectID,
+				"tenant_id":  projectID,
+			},
+		})
+	case http.MethodDelete:
+		s.deleteTopology(projectID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// AddRoutedNetwork adds a network, a subnet and a router that has an interface on the subnet, as a user would create them,
+// e.g. to simulate a network that looks like the topology network. It returns the network ID.
+func (s *Server) AddRoutedNetwork(projectID, networkName, routerName string) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.addRoutedNetwork(projectID, networkName, routerName)
+}
+
+// allocateTopology creates network, subnet and router of the topology, must be called with lock held
+func (s *Server) allocateTopology(projectID string) string {
+	networkID := s.addRoutedNetwork(projectID, "auto_allocated_network", "auto_allocated_router")
+	s.topologies[projectID] = networkID
+	return networkID
+}
+
+// addRoutedNetwork must be called with lock held
+func (s *Server) addRoutedNetwork(projectID, networkName, routerName string) string {
+	s.ipCounter++
+	net := &network{ID: newID(), Name: networkName, ProjectID: projectID, MTU: 1450}
+	sub := &subnet{
+		ID:        newID(),
+		NetworkID: net.ID,
+		ProjectID: projectID,
+		CIDR:      fmt.Sprintf("10.%d.0.0/24", s.ipCounter),
+		GatewayIP: fmt.Sprintf("10.%d.0.1", s.ipCounter),
+		IPVersion: 4,
+	}
+	rtr := &router{
+		ID:                newID(),
+		Name:              routerName,
+		ProjectID:         projectID,
+		ExternalNetworkID: ExternalNetworkID,
+		ExternalFixedIPs:  []fixedIP{{SubnetID: externalSubnetID, IPAddress: fmt.Sprintf("203.0.113.%d", 1+s.ipCounter)}},
+	}
+	interfacePort := &port{
+		ID:          newID(),
+		NetworkID:   net.ID,
+		DeviceID:    rtr.ID,
+		DeviceOwner: "network:router_interface",
+		FixedIPs:    []fixedIP{{SubnetID: sub.ID, IPAddress: sub.GatewayIP}},
+	}
+	s.networks[net.ID] = net
+	s.subnets[sub.ID] = sub
+	s.routers[rtr.ID] = rtr
+	s.ports[interfacePort.ID] = interfacePort
+	return net.ID
+}
+
+// deleteTopology must be called with lock held
+func (s *Server) deleteTopology(projectID string) {
+	networkID, ok := s.topologies[projectID]
+	if !ok {
+		return
+	}
+	for id, p := range s.ports {
+		if p.NetworkID == networkID {
+			delete(s.routers, p.DeviceID)
+			delete(s.ports, id)
+		}
+	}
+	for id, sub := range s.subnets {
+		if sub.NetworkID == networkID {
+			delete(s.subnets, id)
+		}
+	}
+	delete(s.networks, networkID)
+	delete(s.topologies, projectID)
+}
+
+// https://docs.openstack.org/api-ref/network/v2/index.html#list-networks
+func (s *Server) handleNetworks(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	networks := []map[string]interface{}{}
+	for _, net := range s.networks {
+		if !matchFilter(query, "project_id", net.ProjectID) || !matchFilter(query, "name", net.Name) || !matchFilter(query, "id", net.ID) {
+			continue
+		}
+		networks = append(networks, s.networkJSON(net))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"networks": networks})
+}
+
+// https://docs.openstack.org/api-ref/network/v2/index.html#show-network-details
+func (s *Server) handleNetwork(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/network/v2.0/networks/")
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	net, ok := s.networks[id]
+	if !ok {
+		writeNeutronError(w, http.StatusNotFound, "NetworkNotFound", fmt.Sprintf("Network %s could not be found.", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"network": s.networkJSON(net)})
+}
+
+// networkJSON must be called with lock held
+func (s *Server) networkJSON(net *network) map[string]interface{} {
+	subnetIDs := []string{}
+	for _, sub := range s.subnets {
+		if sub.NetworkID == net.ID {
+			subnetIDs = append(subnetIDs, sub.ID)
+		}
+	}
+	return map[string]interface{}{
+		"id":              net.ID,
+		"name":            net.Name,
+		"project_id":      net.ProjectID,
+		"tenant_id":       net.ProjectID,
+		"mtu":             net.MTU,
+		"router:external": net.External,
+		"is_default":      net.IsDefault,
+		"status":          "ACTIVE",
+		"admin_state_up":  true,
+		"subnets":         subnetIDs,
+	}
+}
+
+// https://docs.openstack.org/api-ref/network/v2/index.html#list-subnets
+func (s *Server) handleSubnets(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	subnets := []map[string]interface{}{}
+	for _, sub := range s.subnets {
+		if !matchFilter(query, "network_id", sub.NetworkID) {
+			continue
+		}
+		subnets = append(subnets, map[string]interface{}{
+			"id":         sub.ID,
+			"network_id": sub.NetworkID,
+			"project_id": sub.ProjectID,
+			"tenant_id":  sub.ProjectID,
+			"cidr":       sub.CIDR,
+			"gateway_ip": sub.GatewayIP,
+			"ip_version": sub.IPVersion,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"subnets": subnets})
+}
+
+// https://docs.openstack.org/api-ref/network/v2/index.html#list-ports
+func (s *Server) handlePorts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ports := []map[string]interface{}{}
+	for _, p := range s.ports {
+		if !matchFilter(query, "network_id", p.NetworkID) || !matchFilter(query, "device_owner", p.DeviceOwner) || !matchFilter(query, "device_id", p.DeviceID) {
+			continue
+		}
+		ports = append(ports, map[string]interface{}{
+			"id":           p.ID,
+			"network_id":   p.NetworkID,
+			"device_id":    p.DeviceID,
+			"device_owner": p.DeviceOwner,
+			"fixed_ips":    p.FixedIPs,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"ports": ports})
+}
+
+// https://docs.openstack.org/api-ref/network/v2/index.html#list-routers
+func (s *Server) handleRouters(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	routers := []map[string]interface{}{}
+	for _, rtr := range s.routers {
+		if !matchFilter(query, "project_id", rtr.ProjectID) || !matchFilter(query, "name", rtr.Name) {
+			continue
+		}
+		routers = append(routers, map[string]interface{}{
+			"id":         rtr.ID,
+			"name":       rtr.Name,
+			"project_id": rtr.ProjectID,
+			"tenant_id":  rtr.ProjectID,
+			"status":     "ACTIVE",
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"routers": routers})
+}
+
+// https://docs.openstack.org/api-ref/network/v2/index.html#show-router-details
+func (s *Server) handleRouter(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/network/v2.0/routers/")
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	rtr, ok := s.routers[id]
+	if !ok {
+		writeNeutronError(w, http.StatusNotFound, "RouterNotFound", fmt.Sprintf("Router %s could not be found.", id))
+		return
+	}
+	var gateway interface{}
+	if rtr.ExternalNetworkID != "" {
+		gateway = map[string]interface{}{
+			"network_id":         rtr.ExternalNetworkID,
+			"external_fixed_ips": rtr.ExternalFixedIPs,
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"router": map[string]interface{}{
+			"id":                    rtr.ID,
+			"name":                  rtr.Name,
+			"project_id":            rtr.ProjectID,
+			"tenant_id":             rtr.ProjectID,
+			"status":                "ACTIVE",
+			"external_gateway_info": gateway,
+		},
+	})
+}
+
+// matchFilter checks a value against a filter in query, a filter can be repeated to match any of the values
+func matchFilter(query map[string][]string, key, value string) bool {
+	values, ok := query[key]
+	if !ok {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,277 @@
+// Package fake is an in-memory fake of Keystone (Identity v3) and Neutron (Network v2.0) API on httptest.Server,
+// for exercising the provider end-to-end without a live cloud.
+//
+// Only the endpoints used by the provider are implemented:
+//   - Keystone: tokens, catalog and projects of user
+//   - Neutron: version document, auto-allocated-topology, networks, subnets, ports and routers
+//
+// Faults (error status, latency) can be injected, and the default external network can be removed
+// to simulate a cloud that does not meet the prerequisites of auto allocated topology.
+package fake
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// credentials and entities that the server is populated with
+const (
+	RegionName = "RegionOne"
+
+	DomainID   = "default"
+	DomainName = "Default"
+
+	UserID   = "fake-user-id"
+	Username = "fake-user"
+	Password = "fake-password"
+
+	ApplicationCredentialID     = "fake-app-cred-id"
+	ApplicationCredentialName   = "fake-app-cred"
+	ApplicationCredentialSecret = "fake-app-cred-secret"
+
+	ProjectID   = "fake-project-id"
+	ProjectName = "fake-project"
+
+	ExternalNetworkID = "fake-external-network-id"
+	externalSubnetID  = "fake-external-subnet-id"
+)
+
+// Fault is a failure injected into the responses of the server
+type Fault struct {
+	Method     string        // only requests with this method, empty matches all methods
+	PathPrefix string        // only requests with this path prefix (e.g. /network/v2.0/auto-allocated-topology), empty matches all paths
+	StatusCode int           // respond with this status instead of handling the request, 0 to only inject latency
+	RetryAfter string        // Retry-After header of the response, if StatusCode is set
+	Latency    time.Duration // delay before responding
+	Count      int           // number of requests the fault applies to, 0 means unlimited
+}
+
+func (f Fault) matches(r *http.Request) bool {
+	if f.Method != "" && f.Method != r.Method {
+		return false
+	}
+	return strings.HasPrefix(r.URL.Path, f.PathPrefix)
+}
+
+// Server is the fake Keystone and Neutron server.
+// Keystone is served under /identity and Neutron under /network.
+// The internal endpoint of Keystone in the catalog is /internal/identity, so that tests can tell which interface is used.
+type Server struct {
+	server *httptest.Server
+
+	lock     sync.Mutex
+	faults   []*Fault
+	requests map[string]int // number of requests by "<method> <path>"
+
+	// keystone
+	tokenTTL       time.Duration
+	tokens         map[string]fakeToken
+	projects       []project
+	networkCatalog NetworkCatalog
+
+	// neutron
+	networks   map[string]*network
+	subnets    map[string]*subnet
+	routers    map[string]*router
+	ports      map[string]*port
+	topologies map[string]string // network ID of topology by project ID
+	ipCounter  int
+
+	autoAllocationDisabled bool
+}
+
+// NewServer starts a fake server, it should be closed by Close()
+func NewServer() *Server {
+	s := &Server{
+		tokenTTL: time.Hour,
+		requests: make(map[string]int),
+		tokens:   make(map[string]fakeToken),
+		projects: []project{
+			{ID: ProjectID, Name: ProjectName},
+			{ID: "fake-other-project-id", Name: "fake-other-project"},
+		},
+		networks:   make(map[string]*network),
+		subnets:    make(map[string]*subnet),
+		routers:    make(map[string]*router),
+		ports:      make(map[string]*port),
+		topologies: make(map[string]string),
+	}
+	s.SetDefaultExternalNetwork(true)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/identity/v3/auth/tokens", s.handleTokens)
+	mux.HandleFunc("/identity/v3/auth/catalog", s.authenticated(s.handleCatalog))
+	mux.HandleFunc("/identity/v3/users/", s.authenticated(s.handleUserProjects))
+	mux.Handle("/internal/identity/", http.StripPrefix("/internal", mux))
+	mux.HandleFunc("/network/", s.handleNetworkVersions)
+	mux.HandleFunc("/network/v2.0/auto-allocated-topology/", s.authenticated(s.handleAutoAllocatedTopology))
+	mux.HandleFunc("/network/v2.0/networks", s.authenticated(s.handleNetworks))
+	mux.HandleFunc("/network/v2.0/networks/", s.authenticated(s.handleNetwork))
+	mux.HandleFunc("/network/v2.0/subnets", s.authenticated(s.handleSubnets))
+	mux.HandleFunc("/network/v2.0/ports", s.authenticated(s.handlePorts))
+	mux.HandleFunc("/network/v2.0/routers", s.authenticated(s.handleRouters))
+	mux.HandleFunc("/network/v2.0/routers/", s.authenticated(s.handleRouter))
+	s.server = httptest.NewServer(s.withFaults(mux))
+	return s
+}
+
+// Close shuts down the server
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// URL is the base URL of the server
+func (s *Server) URL() string {
+	return s.server.URL
+}
+
+// AuthURL is the Keystone URL to authenticate with (OS_AUTH_URL)
+func (s *Server) AuthURL() string {
+	return s.server.URL + "/identity/v3"
+}
+
+// NetworkURL is the Neutron URL in the catalog, it is listed without version
+func (s *Server) NetworkURL() string {
+	return s.server.URL + "/network"
+}
+
+// InjectFault adds a fault, faults are checked in the order they are added
+func (s *Server) InjectFault(fault Fault) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.faults = append(s.faults, &fault)
+}
+
+// ClearFaults removes all injected faults
+func (s *Server) ClearFaults() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.faults = nil
+}
+
+// RequestCount returns the number of requests received with the method and path, query is not part of path
+func (s *Server) RequestCount(method, path string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.requests[method+" "+path]
+}
+
+// SetTokenTTL sets how long tokens issued afterwards are valid
+func (s *Server) SetTokenTTL(ttl time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.tokenTTL = ttl
+}
+
+// RevokeTokens revokes all issued tokens, requests with them are rejected with 401
+func (s *Server) RevokeTokens() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.tokens = make(map[string]fakeToken)
+}
+
+// IssueToken issues a token for the default user scoped to the default project, for token authentication (OS_TOKEN)
+func (s *Server) IssueToken() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.issueToken(ProjectID, []string{"password"})
+}
+
+func (s *Server) withFaults(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		s.requests[r.Method+" "+r.URL.Path]++
+		fault := s.takeFault(r)
+		s.lock.Unlock()
+
+		if fault.Latency > 0 {
+			select {
+			case <-time.After(fault.Latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if fault.StatusCode != 0 {
+			if fault.RetryAfter != "" {
+				w.Header().Set("Retry-After", fault.RetryAfter)
+			}
+			writeNeutronError(w, fault.StatusCode, "InjectedFault", "fault injected by fake server")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// takeFault returns the first fault that matches the request and consumes one of its count, must be called with lock held
+func (s *Server) takeFault(r *http.Request) Fault {
+	for i, fault := range s.faults {
+		if !fault.matches(r) {
+			continue
+		}
+		if fault.Count > 0 {
+			fault.Count--
+			if fault.Count == 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+		}
+		return *fault
+	}
+	return Fault{}
+}
+
+// authenticated rejects requests without a valid token
+func (s *Server) authenticated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		_, ok := s.validToken(r.Header.Get("X-Auth-Token"))
+		s.lock.Unlock()
+		if !ok {
+			writeKeystoneError(w, http.StatusUnauthorized, "The request you have made requires authentication.")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Openstack-Request-Id", "req-"+newID())
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeNeutronError(w http.ResponseWriter, status int, errType, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"NeutronError": map[string]string{
+			"type":    errType,
+			"message": message,
+			"detail":  "",
+		},
+	})
+}
+
+func writeKeystoneError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    status,
+			"title":   http.StatusText(status),
+			"message": message,
+		},
+	})
+}
+
+func newID() string {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		panic(fmt.Sprintf("fail to generate ID, %s", err))
+	}
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,90 @@
+package openstack
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+// loggingTransport logs every request to OpenStack API (method, URL, status, latency and request ID).
+// Headers are never logged, since they carry the token.
+type loggingTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip ...
+func (t *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	fields := map[string]interface{}{
+		"method": req.Method,
+		"url":    redactURL(req.URL),
+	}
+	tflog.Trace(ctx, "sending OpenStack API request", fields)
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	fields["latency_ms"] = time.Since(start).Milliseconds()
+	if err != nil {
+		fields["error"] = err.Error()
+		tflog.Debug(ctx, "OpenStack API request failed", fields)
+		return resp, err
+	}
+	fields["status"] = resp.StatusCode
+	fields["request_id"] = requestIDFromHeader(resp.Header)
+	tflog.Debug(ctx, "OpenStack API request", fields)
+	return resp, nil
+}
+
+// redactURL removes user info and masks values in the query, in case a secret is passed in the URL
+func redactURL(u *url.URL) string {
+	redacted := *u
+	redacted.User = nil
+	if redacted.RawQuery != "" {
+		query := redacted.Query()
+		for key := range query {
+			if isSecretQueryKey(key) {
+				query.Set(key, maskSecret(query.Get(key)))
+			}
+		}
+		redacted.RawQuery = query.Encode()
+	}
+	return redacted.String()
+}
+
+func isSecretQueryKey(key string) bool {
+	switch key {
+	case "token", "password", "secret", "application_credential_secret":
+		return true
+	}
+	return false
+}
+
+// maskSecret masks a secret for logging, only whether it is set is revealed
+func maskSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "***"
+}
+
+// authLogFields are the fields of the credential to log on authentication, secrets are masked
+func (cred CredentialEnv) authLogFields(method AuthMethod) map[string]interface{} {
+	return map[string]interface{}{
+		"auth_method":                   string(method),
+		"auth_url":                      cred.AuthURL,
+		"cloud":                         cred.Cloud,
+		"region":                        cred.RegionName,
+		"interface":                     cred.Interface,
+		"user_name":                     cred.Username,
+		"user_id":                       cred.UserID,
+		"project_id":                    cred.ProjectID,
+		"project_name":                  cred.ProjectName,
+		"application_credential_id":     cred.ApplicationCredentialID,
+		"application_credential_name":   cred.ApplicationCredentialName,
+		"application_credential_secret": maskSecret(cred.ApplicationCredentialSecret),
+		"password":                      maskSecret(cred.Password),
+		"token":                         maskSecret(cred.Token),
+	}
+}
@@ -0,0 +1,171 @@
+// Package mock is a hand-written test double of openstack.IdentityAPI and openstack.NetworkAPI.
+//
+// Each method calls the function field of the same name (with Func suffix) if it is set,
+// otherwise it returns zero values. Calls are recorded, and are safe for concurrent use.
+//
+//	network := &mock.NetworkAPI{
+//		GetAutoAllocatedTopologyFunc: func(ctx context.Context, projectID string) (*openstack.AutoAllocatedTopology, error) {
+//			return &openstack.AutoAllocatedTopology{NetworkID: "net-1", ProjectID: projectID}, nil
+//		},
+//	}
+//	identity := &mock.IdentityAPI{ProjectID: "project-1", Region: "RegionOne", NetworkAPI: network}
+package mock
+
+import (
+	"context"
+	"sync"
+
+	"gitlab.com/cyverse/openstack-auto-allocated-topology/openstack"
+)
+
+// Call is a recorded call of a mock method
+type Call struct {
+	Method string
+	Args   []interface{} // arguments except context
+}
+
+// recorder records calls, embedded by the mocks
+type recorder struct {
+	lock  sync.Mutex
+	calls []Call
+}
+
+func (r *recorder) record(method string, args ...interface{}) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.calls = append(r.calls, Call{Method: method, Args: args})
+}
+
+// Calls returns the recorded calls in order
+func (r *recorder) Calls() []Call {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]Call(nil), r.calls...)
+}
+
+// CallCount returns the number of calls of a method
+func (r *recorder) CallCount(method string) int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var count int
+	for _, call := range r.calls {
+		if call.Method == method {
+			count++
+		}
+	}
+	return count
+}
+
+// IdentityAPI is a mock of openstack.IdentityAPI.
+// CurrentProject and CurrentRegion return the fields, Network returns NetworkAPI unless NetworkFunc is set.
+type IdentityAPI struct {
+	recorder
+
+	ProjectID   string
+	ProjectName string
+	Region      string
+	NetworkAPI  openstack.NetworkAPI
+
+	LookupProjectByNameFunc func(ctx context.Context, projectName string) (string, error)
+	ListProjectsFunc        func(ctx context.Context) ([]openstack.Project, error)
+	NetworkFunc             func(ctx context.Context, regionName string) (openstack.NetworkAPI, error)
+}
+
+var _ openstack.IdentityAPI = (*IdentityAPI)(nil)
+
+// CurrentProject ...
+func (m *IdentityAPI) CurrentProject() (id string, name string) {
+	m.record("CurrentProject")
+	return m.ProjectID, m.ProjectName
+}
+
+// CurrentRegion ...
+func (m *IdentityAPI) CurrentRegion() (name string) {
+	m.record("CurrentRegion")
+	return m.Region
+}
+
+// LookupProjectByName ...
+func (m *IdentityAPI) LookupProjectByName(ctx context.Context, projectName string) (id string, err error) {
+	m.record("LookupProjectByName", projectName)
+	if m.LookupProjectByNameFunc == nil {
+		return "", nil
+	}
+	return m.LookupProjectByNameFunc(ctx, projectName)
+}
+
+// ListProjects ...
+func (m *IdentityAPI) ListProjects(ctx context.Context) ([]openstack.Project, error) {
+	m.record("ListProjects")
+	if m.ListProjectsFunc == nil {
+		return nil, nil
+	}
+	return m.ListProjectsFunc(ctx)
+}
+
+// Network ...
+func (m *IdentityAPI) Network(ctx context.Context, regionName string) (openstack.NetworkAPI, error) {
+	m.record("Network", regionName)
+	if m.NetworkFunc == nil {
+		return m.NetworkAPI, nil
+	}
+	return m.NetworkFunc(ctx, regionName)
+}
+
+// NetworkAPI is a mock of openstack.NetworkAPI
+type NetworkAPI struct {
+	recorder
+
+	GetAutoAllocatedTopologyFunc        func(ctx context.Context, projectID string) (*openstack.AutoAllocatedTopology, error)
+	FindAutoAllocatedTopologyFunc       func(ctx context.Context, projectID string) (*openstack.AutoAllocatedTopology, error)
+	GetAutoAllocatedTopologyDetailsFunc func(ctx context.Context, topology *openstack.AutoAllocatedTopology) error
+	ValidateAutoAllocatedTopologyFunc   func(ctx context.Context, projectID string) error
+	DeleteAutoAllocatedTopologyFunc     func(ctx context.Context, projectID string) error
+}
+
+var _ openstack.NetworkAPI = (*NetworkAPI)(nil)
+
+// GetAutoAllocatedTopology ...
+func (m *NetworkAPI) GetAutoAllocatedTopology(ctx context.Context, projectID string) (*openstack.AutoAllocatedTopology, error) {
+	m.record("GetAutoAllocatedTopology", projectID)
+	if m.GetAutoAllocatedTopologyFunc == nil {
+		return nil, nil
+	}
+	return m.GetAutoAllocatedTopologyFunc(ctx, projectID)
+}
+
+// FindAutoAllocatedTopology ...
+func (m *NetworkAPI) FindAutoAllocatedTopology(ctx context.Context, projectID string) (*openstack.AutoAllocatedTopology, error) {
+	m.record("FindAutoAllocatedTopology", projectID)
+	if m.FindAutoAllocatedTopologyFunc == nil {
+		return nil, nil
+	}
+	return m.FindAutoAllocatedTopologyFunc(ctx, projectID)
+}
+
+// GetAutoAllocatedTopologyDetails ...
+func (m *NetworkAPI) GetAutoAllocatedTopologyDetails(ctx context.Context, topology *openstack.AutoAllocatedTopology) error {
+	m.record("GetAutoAllocatedTopologyDetails", topology)
+	if m.GetAutoAllocatedTopologyDetailsFunc == nil {
+		return nil
+	}
+	return m.GetAutoAllocatedTopologyDetailsFunc(ctx, topology)
+}
+
+// ValidateAutoAllocatedTopology ...
+func (m *NetworkAPI) ValidateAutoAllocatedTopology(ctx context.Context, projectID string) error {
+	m.record("ValidateAutoAllocatedTopology", projectID)
+	if m.ValidateAutoAllocatedTopologyFunc == nil {
+		return nil
+	}
+	return m.ValidateAutoAllocatedTopologyFunc(ctx, projectID)
+}
+
+// DeleteAutoAllocatedTopology ...
+func (m *NetworkAPI) DeleteAutoAllocatedTopology(ctx context.Context, projectID string) error {
+	m.record("DeleteAutoAllocatedTopology", projectID)
+	if m.DeleteAutoAllocatedTopologyFunc == nil {
+		return nil
+	}
+	return m.DeleteAutoAllocatedTopologyFunc(ctx, projectID)
+}
@@ -2,27 +2,44 @@ package openstack
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 )
 
+// autoAllocatedRouterName is the name Neutron gives to the router it creates for the auto allocated topology
+const autoAllocatedRouterName = "auto_allocated_router"
+
+// routerInterfaceOwners are the device owners of the ports that attach a router to a network
+var routerInterfaceOwners = []string{
+	"network:router_interface",
+	"network:router_interface_distributed",
+	"network:ha_router_replicated_interface",
+}
+
 // NetworkClient is client for OpenStack Network (Neutron) API
 type NetworkClient struct {
-	baseURL       string // base URL for network API
-	token         string
-	tokenMetadata TokenMetadata
+	baseURL     string  // versioned base URL for network API, e.g. https://example.com:9696/v2.0
+	client      *Client // parent client, token is obtained from it so that renewed token is used
+	httpClient  *http.Client
+	retryPolicy RetryPolicy
+	userAgent   string
 }
 
 // GetAutoAllocatedTopology get (or create if not exists) the auto allocated topology of a project.
 // https://docs.openstack.org/api-ref/network/v2/?expanded=show-auto-allocated-topology-details-detail#show-auto-allocated-topology-details
-func (c NetworkClient) GetAutoAllocatedTopology(projectID string) (*AutoAllocatedTopology, error) {
+func (c NetworkClient) GetAutoAllocatedTopology(ctx context.Context, projectID string) (*AutoAllocatedTopology, error) {
 
-	url := fmt.Sprintf("%s/v2.0/auto-allocated-topology/%s", c.baseURL, projectID)
-	resp, err := makeRequest(http.MethodGet, url, c.token, nil, []int{200})
+	url := fmt.Sprintf("%s/auto-allocated-topology/%s", c.baseURL, projectID)
+	resp, err := c.request(ctx, http.MethodGet, url, nil, []int{200})
 	if err != nil {
-		return nil, err
+		return nil, autoAllocationError(err, projectID)
 	}
+	defer resp.Body.Close()
 	var respBody struct {
 		Topology struct {
 			ID        string `json:"id"`
@@ -34,7 +51,6 @@ func (c NetworkClient) GetAutoAllocatedTopology(projectID string) (*AutoAllocate
 	if err != nil {
 		return nil, err
 	}
-	resp.Body.Close()
 
 	return &AutoAllocatedTopology{
 		NetworkID: respBody.Topology.ID,
@@ -42,26 +58,253 @@ func (c NetworkClient) GetAutoAllocatedTopology(projectID string) (*AutoAllocate
 	}, nil
 }
 
+// FindAutoAllocatedTopology looks up the existing auto allocated topology of a project, without creating one.
+// There is no API to get the topology without creating it, so this looks for the router that Neutron creates for the topology
+// (auto_allocated_router), and the topology network is the network the router has an interface on.
+// A network named auto_allocated_network by user is not mistaken for the topology, and renaming the topology network does not hide it,
+// but renaming the router does. An error is returned if the project has more than one such router with interfaces on different networks.
+// nil is returned if the project does not have an auto allocated topology.
+// https://docs.openstack.org/api-ref/network/v2/index.html#list-routers
+func (c NetworkClient) FindAutoAllocatedTopology(ctx context.Context, projectID string) (*AutoAllocatedTopology, error) {
+
+	query := url.Values{}
+	query.Set("project_id", projectID)
+	query.Set("name", autoAllocatedRouterName)
+	var routerResp struct {
+		Routers []struct {
+			ID string `json:"id"`
+		} `json:"routers"`
+	}
+	err := c.getJSON(ctx, fmt.Sprintf("%s/routers?%s", c.baseURL, query.Encode()), &routerResp)
+	if err != nil {
+		return nil, err
+	}
+
+	var networkIDs []string
+	for _, router := range routerResp.Routers {
+		query = url.Values{}
+		query.Set("device_id", router.ID)
+		query["device_owner"] = routerInterfaceOwners
+		var portResp struct {
+			Ports []struct {
+				NetworkID string `json:"network_id"`
+			} `json:"ports"`
+		}
+		err = c.getJSON(ctx, fmt.Sprintf("%s/ports?%s", c.baseURL, query.Encode()), &portResp)
+		if err != nil {
+			return nil, err
+		}
+		for _, port := range portResp.Ports {
+			if !containsString(networkIDs, port.NetworkID) {
+				networkIDs = append(networkIDs, port.NetworkID)
+			}
+		}
+	}
+	if len(networkIDs) == 0 {
+		return nil, nil
+	}
+	if len(networkIDs) > 1 {
+		return nil, fmt.Errorf("fail to find auto allocated topology of project %s, %s routers are attached to more than one network %v", projectID, autoAllocatedRouterName, networkIDs)
+	}
+
+	return &AutoAllocatedTopology{
+		NetworkID: networkIDs[0],
+		ProjectID: projectID,
+	}, nil
+}
+
+func containsString(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateAutoAllocatedTopology checks whether the prerequisites (default external network and default subnet pools)
+// of auto allocated topology are met for a project, without creating anything.
+// AutoAllocationPrerequisiteError is returned if the prerequisites are not met.
+// https://docs.openstack.org/api-ref/network/v2/?expanded=show-auto-allocated-topology-details-detail#show-auto-allocated-topology-details
+func (c NetworkClient) ValidateAutoAllocatedTopology(ctx context.Context, projectID string) error {
+
+	url := fmt.Sprintf("%s/auto-allocated-topology/%s?fields=dry-run", c.baseURL, projectID)
+	resp, err := c.request(ctx, http.MethodGet, url, nil, []int{200})
+	if err != nil {
+		return autoAllocationError(err, projectID)
+	}
+	defer resp.Body.Close()
+	return nil
+}
+
+// autoAllocationError converts the error from auto-allocated-topology API,
+// conflict means the prerequisites are not met, and not found means the extension is not enabled.
+func autoAllocationError(err error, projectID string) error {
+	var conflict ConflictError
+	if errors.As(err, &conflict) {
+		return AutoAllocationPrerequisiteError{
+			ProjectID: projectID,
+			Reason:    conflict.Message,
+			RequestID: conflict.RequestID,
+		}
+	}
+	return asExtensionMissing(err, "auto-allocated-topology")
+}
+
+// AutoAllocationPrerequisiteError is the error when the prerequisites of auto allocated topology are not met,
+// e.g. no default external network or no default subnet pools.
+type AutoAllocationPrerequisiteError struct {
+	ProjectID string
+	Region    string // region of the project, optional since NetworkClient does not know the name of its region
+	Reason    string // reason reported by Neutron
+	RequestID string
+}
+
+// Error ...
+func (e AutoAllocationPrerequisiteError) Error() string {
+	return fmt.Sprintf("auto allocated topology prerequisites are not met for %s, %s", e.target(), e.Reason)
+}
+
+// target is the project (and region if known) that the prerequisites are checked for
+func (e AutoAllocationPrerequisiteError) target() string {
+	if e.Region == "" {
+		return fmt.Sprintf("project %s", e.ProjectID)
+	}
+	return fmt.Sprintf("project %s in region %s", e.ProjectID, e.Region)
+}
+
 // DeleteAutoAllocatedTopology deletes the auto allocated topology for a project.
 // https://docs.openstack.org/api-ref/network/v2/?expanded=delete-the-auto-allocated-topology-detail#show-auto-allocated-topology-details
-func (c NetworkClient) DeleteAutoAllocatedTopology(projectID string) error {
+func (c NetworkClient) DeleteAutoAllocatedTopology(ctx context.Context, projectID string) error {
 
-	url := fmt.Sprintf("%s/v2.0/auto-allocated-topology/%s", c.baseURL, projectID)
-	resp, err := makeRequest(http.MethodDelete, url, c.token, nil, []int{200, 204})
+	url := fmt.Sprintf("%s/auto-allocated-topology/%s", c.baseURL, projectID)
+	resp, err := c.request(ctx, http.MethodDelete, url, nil, []int{200, 204})
 	if err != nil {
-		return err
+		return asExtensionMissing(err, "auto-allocated-topology")
 	}
+	defer resp.Body.Close()
 	var buf bytes.Buffer
 	_, err = buf.ReadFrom(resp.Body)
 	if err != nil {
 		return err
 	}
-	resp.Body.Close()
 	return nil
 }
 
+// GetAutoAllocatedTopologyDetails fills in the details of the topology: network name and MTU, subnets, router and its external gateway.
+// Router is the one that has an interface on the topology network, it is left empty if there is no such router.
+// https://docs.openstack.org/api-ref/network/v2/index.html
+func (c NetworkClient) GetAutoAllocatedTopologyDetails(ctx context.Context, topology *AutoAllocatedTopology) error {
+
+	var networkResp struct {
+		Network struct {
+			Name string `json:"name"`
+			MTU  int    `json:"mtu"`
+		} `json:"network"`
+	}
+	err := c.getJSON(ctx, fmt.Sprintf("%s/networks/%s", c.baseURL, topology.NetworkID), &networkResp)
+	if err != nil {
+		return err
+	}
+	topology.Name = networkResp.Network.Name
+	topology.MTU = networkResp.Network.MTU
+
+	query := url.Values{}
+	query.Set("network_id", topology.NetworkID)
+	var subnetResp struct {
+		Subnets []TopologySubnet `json:"subnets"`
+	}
+	err = c.getJSON(ctx, fmt.Sprintf("%s/subnets?%s", c.baseURL, query.Encode()), &subnetResp)
+	if err != nil {
+		return err
+	}
+	topology.Subnets = subnetResp.Subnets
+
+	query = url.Values{}
+	query.Set("network_id", topology.NetworkID)
+	query["device_owner"] = routerInterfaceOwners
+	var portResp struct {
+		Ports []struct {
+			DeviceID string `json:"device_id"`
+		} `json:"ports"`
+	}
+	err = c.getJSON(ctx, fmt.Sprintf("%s/ports?%s", c.baseURL, query.Encode()), &portResp)
+	if err != nil {
+		return err
+	}
+	if len(portResp.Ports) == 0 {
+		return nil
+	}
+	topology.RouterID = portResp.Ports[0].DeviceID
+
+	var routerResp struct {
+		Router struct {
+			ExternalGatewayInfo *struct {
+				NetworkID        string            `json:"network_id"`
+				ExternalFixedIPs []TopologyFixedIP `json:"external_fixed_ips"`
+			} `json:"external_gateway_info"`
+		} `json:"router"`
+	}
+	err = c.getJSON(ctx, fmt.Sprintf("%s/routers/%s", c.baseURL, topology.RouterID), &routerResp)
+	if err != nil {
+		return err
+	}
+	if gateway := routerResp.Router.ExternalGatewayInfo; gateway != nil {
+		topology.ExternalNetworkID = gateway.NetworkID
+		topology.ExternalFixedIPs = gateway.ExternalFixedIPs
+	}
+	return nil
+}
+
+// getJSON makes a GET request and decodes the JSON response body into respBody
+func (c NetworkClient) getJSON(ctx context.Context, url string, respBody interface{}) error {
+	resp, err := c.request(ctx, http.MethodGet, url, nil, []int{200})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(respBody)
+}
+
+// request makes a request with the current token, the token is renewed and request is retried once if the token is rejected (401)
+func (c NetworkClient) request(ctx context.Context, httpMethod string, url string, body io.Reader, successStatusCodes []int) (*http.Response, error) {
+	token, err := c.client.currentToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := makeRequest(ctx, c.httpClient, c.retryPolicy, c.userAgent, httpMethod, url, token, body, successStatusCodes)
+	if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	token, err = c.client.reauthenticate(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return makeRequest(ctx, c.httpClient, c.retryPolicy, c.userAgent, httpMethod, url, token, body, successStatusCodes)
+}
+
 // AutoAllocatedTopology is network (and related entities) that created by openstack via the auto allocated topology extension
 type AutoAllocatedTopology struct {
-	NetworkID string `json:"network_id"`
-	ProjectID string `json:"project_id"`
+	NetworkID         string            `json:"network_id"`
+	Name              string            `json:"name"`
+	ProjectID         string            `json:"project_id"`
+	MTU               int               `json:"mtu"`
+	Subnets           []TopologySubnet  `json:"subnets"`
+	RouterID          string            `json:"router_id"`
+	ExternalNetworkID string            `json:"external_network_id"`
+	ExternalFixedIPs  []TopologyFixedIP `json:"external_fixed_ips"`
+}
+
+// TopologySubnet is a subnet of the auto allocated topology network
+type TopologySubnet struct {
+	ID        string `json:"id"`
+	CIDR      string `json:"cidr"`
+	GatewayIP string `json:"gateway_ip"`
+	IPVersion int    `json:"ip_version"`
+}
+
+// TopologyFixedIP is a fixed IP of the router external gateway
+type TopologyFixedIP struct {
+	SubnetID  string `json:"subnet_id"`
+	IPAddress string `json:"ip_address"`
 }
@@ -2,81 +2,142 @@ package openstack
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack"
-	"github.com/gophercloud/gophercloud/openstack/identity/v3/catalog"
 	"github.com/gophercloud/gophercloud/openstack/identity/v3/users"
-	"github.com/gophercloud/gophercloud/openstack/networking/v2/networks"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
 	"github.com/mitchellh/mapstructure"
 	"io"
 	"net/http"
+	"sync"
 	"time"
 )
 
+// UserAgentProductName is the product name in the User-Agent of requests, shared by the provider and the CLI
+const UserAgentProductName = "terraform-provider-openstack-auto-topology"
+
 // Client is base client for OpenStack API
 type Client struct {
 	credEnv        CredentialEnv
-	token          string
+	authMethod     AuthMethod
+	tokenLock      sync.RWMutex // protects tokenMetadata and endpointCache, token itself is stored in provider
 	tokenMetadata  TokenMetadata
-	catalogEntries []CatalogEntry
+	endpointCache  map[endpointKey]CatalogEndpoint // endpoints resolved from the catalog in tokenMetadata
 	provider       *gophercloud.ProviderClient
+	authOptions    gophercloud.AuthOptions // to obtain a new token when the current one expires
+	reauthLock     sync.Mutex              // only one re-authentication at a time
+	httpClient     *http.Client            // shared by gophercloud and raw requests
+	retryPolicy    RetryPolicy
+	userAgent      string // prepended to the User-Agent of gophercloud, and used as is for raw requests
+	endpointConfig EndpointConfig
+	discoveryLock  sync.Mutex                // protects discoveredURLs and discoveries
+	discoveredURLs map[string]string         // versioned base URL of Network API by endpoint URL in catalog
+	discoveries    map[string]*discoveryCall // version discoveries in flight by endpoint URL
+}
+
+// NewClient creates a new Client.
+// Client should be shared by pointer, since token is renewed in place when it expires.
+func NewClient() *Client {
+	return &Client{retryPolicy: DefaultRetryPolicy()}
+}
+
+// SetUserAgent sets the User-Agent of requests, must be set before Auth() to apply to requests for token
+func (c *Client) SetUserAgent(userAgent string) {
+	c.userAgent = userAgent
+}
+
+// SetEndpointConfig sets how endpoints of services are selected
+func (c *Client) SetEndpointConfig(endpointConfig EndpointConfig) {
+	c.endpointConfig = endpointConfig
 }
 
-// NewClient creates a new Client
-func NewClient() Client {
-	return Client{}
+// SetRetryPolicy sets how requests made by NetworkClient are retried
+func (c *Client) SetRetryPolicy(retryPolicy RetryPolicy) {
+	c.retryPolicy = retryPolicy
 }
 
-// Auth authenticate with OpenStack API using an application credential
-func (c *Client) Auth(credEnv CredentialEnv) error {
-	opts, err := openstack.AuthOptionsFromEnv()
+// Auth authenticate with OpenStack API using the credential, the authentication method is chosen based on what the credential supplies.
+func (c *Client) Auth(ctx context.Context, credEnv CredentialEnv) error {
+	method, err := credEnv.AuthMethod()
 	if err != nil {
 		return err
 	}
+	opts, err := credEnv.AuthOptions(method)
+	if err != nil {
+		return fmt.Errorf("fail to authenticate using %s, %w", method, err)
+	}
 
-	provider, err := openstack.AuthenticatedClient(opts)
+	httpClient, err := newHTTPClient(credEnv)
 	if err != nil {
 		return err
 	}
-	token, metadata, err := obtainToken(provider)
+	provider, err := openstack.NewClient(opts.IdentityEndpoint)
 	if err != nil {
 		return err
 	}
+	// provider.Context is only set to ctx for authentication, later requests use the context passed to them,
+	// see providerWithContext(). Re-authentication is done by reauthenticate() with the context of the request,
+	// instead of gophercloud, whose copy of provider for re-authentication would outlive ctx.
+	provider.HTTPClient = *httpClient
+	provider.Context = ctx
+	if c.userAgent != "" {
+		provider.UserAgent.Prepend(c.userAgent)
+	}
+	opts.AllowReauth = false
+	tflog.Debug(ctx, "authenticating with OpenStack", credEnv.authLogFields(method))
+	err = openstack.Authenticate(provider, opts)
+	provider.Context = nil
+	if err != nil {
+		tflog.Debug(ctx, "fail to authenticate with OpenStack", map[string]interface{}{"auth_method": string(method), "error": err.Error()})
+		return fmt.Errorf("fail to authenticate using %s, %w", method, err)
+	}
+	_, metadata, err := obtainToken(provider)
+	if err != nil {
+		return fmt.Errorf("fail to authenticate using %s, %w", method, err)
+	}
+	tflog.Debug(ctx, "authenticated with OpenStack", map[string]interface{}{
+		"auth_method":      string(method),
+		"user_id":          metadata.User.ID,
+		"project_id":       metadata.Project.ID,
+		"token_expires_at": metadata.ExpiresAt.String(),
+		"catalog_services": len(metadata.Catalog),
+	})
+	c.authMethod = method
 	c.provider = provider
-	c.tokenMetadata = metadata
-	c.token = token
+	c.authOptions = opts
+	c.httpClient = httpClient
+	c.setTokenMetadata(metadata)
 	c.credEnv = credEnv
 	return nil
 }
 
 // Network returns a NetworkClient for a region.
 // If regionName parameter is empty (""), then you will try to use OS_REGION_NAME from application credential.
-func (c *Client) Network(regionName string) (*NetworkClient, error) {
-	if c.token == "" {
-		return nil, fmt.Errorf("token not set")
-	}
-	if regionName == "" {
-		regionName = c.credEnv.RegionName
-	}
-	identityClient, err := openstack.NewIdentityV3(c.provider, gophercloud.EndpointOpts{})
-	if err != nil {
-		return nil, err
-	}
-	entry, err := findNeutronCatalogEntry(identityClient, regionName, c.credEnv.Interface)
+func (c *Client) Network(ctx context.Context, regionName string) (NetworkAPI, error) {
+	baseURL, err := c.resolveNetworkURL(ctx, regionName)
 	if err != nil {
 		return nil, err
 	}
 	return &NetworkClient{
-		baseURL:       entry.URL,
-		token:         c.token,
-		tokenMetadata: c.tokenMetadata,
+		baseURL:     baseURL,
+		client:      c,
+		httpClient:  c.httpClient,
+		retryPolicy: c.retryPolicy,
+		userAgent:   c.userAgent,
 	}, nil
 }
 
+// AuthMethod returns the method used for authentication
+func (c *Client) AuthMethod() AuthMethod {
+	return c.authMethod
+}
+
 // CurrentProject returns the current project (project of application credential used for authentication)
 func (c *Client) CurrentProject() (id string, name string) {
-	return c.tokenMetadata.Project.ID, c.tokenMetadata.Project.Name
+	metadata := c.getTokenMetadata()
+	return metadata.Project.ID, metadata.Project.Name
 }
 
 // CurrentRegion returns the current region specified in credential via environment variables
@@ -85,46 +146,12 @@ func (c *Client) CurrentRegion() (name string) {
 }
 
 // LookupProjectByName looks up the ID of a project by its name
-// https://docs.openstack.org/api-ref/identity/v3/index.html?expanded=list-projects-for-user-detail#list-projects-for-user
-func (c *Client) LookupProjectByName(projectName string) (id string, err error) {
-	identityClient, err := openstack.NewIdentityV3(c.provider, gophercloud.EndpointOpts{})
-	if err != nil {
-		return "", err
-	}
-	list, err := users.ListProjects(identityClient, c.tokenMetadata.User.ID).AllPages()
+func (c *Client) LookupProjectByName(ctx context.Context, projectName string) (id string, err error) {
+	projects, err := c.ListProjects(ctx)
 	if err != nil {
 		return "", err
 	}
-	if empty, err := list.IsEmpty(); err != nil || empty {
-		return "", fmt.Errorf("%w", err)
-	}
-
-	var respBody struct {
-		Projects []struct {
-			ID          string                 `json:"id"`
-			Name        string                 `json:"name"`
-			DomainID    string                 `json:"domain_id"`
-			Description string                 `json:"description"`
-			Enabled     bool                   `json:"enabled"`
-			ParentID    string                 `json:"parent_id"`
-			IsDomain    bool                   `json:"is_domain"`
-			Tags        []string               `json:"tags"`
-			Options     map[string]interface{} `json:"options"`
-			Links       struct {
-				Self string `json:"self"`
-			} `json:"links"`
-		} `json:"projects"`
-		Links struct {
-			Next     interface{} `json:"next"`
-			Self     string      `json:"self"`
-			Previous interface{} `json:"previous"`
-		} `json:"links"`
-	}
-	err = mapstructure.Decode(list.GetBody(), &respBody)
-	if err != nil {
-		return "", err
-	}
-	for _, project := range respBody.Projects {
+	for _, project := range projects {
 		if project.Name == projectName {
 			// return the first found
 			return project.ID, nil
@@ -133,88 +160,54 @@ func (c *Client) LookupProjectByName(projectName string) (id string, err error)
 	return "", fmt.Errorf("project %s not found", projectName)
 }
 
-func (c *Client) LookupNetworkName(regionName, networkID string) (name string, err error) {
-	networkClient, err := openstack.NewNetworkV2(c.provider, gophercloud.EndpointOpts{Region: regionName})
+// ListProjects lists the projects that the user of the credential has role on
+// https://docs.openstack.org/api-ref/identity/v3/index.html?expanded=list-projects-for-user-detail#list-projects-for-user
+func (c *Client) ListProjects(ctx context.Context) ([]Project, error) {
+	provider := c.providerWithContext(ctx)
+	identityBase, err := c.resolveIdentityURL(ctx)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	result := networks.Get(networkClient, networkID)
-	if result.Err != nil {
-		return "", result.Err
+	if identityBase != "" {
+		provider.IdentityBase = gophercloud.NormalizeURL(identityBase)
 	}
-	network, err := result.Extract()
+	identityClient, err := openstack.NewIdentityV3(provider, gophercloud.EndpointOpts{})
 	if err != nil {
-		return "", err
-	}
-	return network.Name, nil
-}
-
-func findNeutronCatalogEntry(identityClient *gophercloud.ServiceClient, regionName, interfaceName string) (CatalogEndpoint, error) {
-	catalogList := catalog.List(identityClient)
-	page, err := catalogList.AllPages()
-	if err != nil {
-		return CatalogEndpoint{}, err
+		return nil, err
 	}
-	empty, err := page.IsEmpty()
+	list, err := users.ListProjects(identityClient, c.getTokenMetadata().User.ID).AllPages()
 	if err != nil {
-		return CatalogEndpoint{}, err
-	}
-	if empty {
-		return CatalogEndpoint{}, fmt.Errorf("catalog is empty")
+		return nil, fromGophercloudError(err)
 	}
+
 	var respBody struct {
-		Catalog []CatalogEntry `json:"catalog"`
-		Links   struct {
-			Self     string      `json:"self"`
-			Previous interface{} `json:"previous"`
-			Next     interface{} `json:"next"`
-		} `json:"links"`
-	}
-	err = mapstructure.Decode(page.GetBody(), &respBody)
-	if err != nil {
-		return CatalogEndpoint{}, err
-	}
-	if len(respBody.Catalog) == 0 {
-		return CatalogEndpoint{}, fmt.Errorf("no catalog entries")
+		Projects []Project `json:"projects"`
 	}
-	endpoint, err := findEndpoint(respBody.Catalog, "network", regionName, interfaceName)
+	err = mapstructure.Decode(list.GetBody(), &respBody)
 	if err != nil {
-		return CatalogEndpoint{}, err
+		return nil, err
 	}
-	return endpoint, nil
+	return respBody.Projects, nil
 }
 
-func findEndpoint(catalogEntries []CatalogEntry, serviceType, regionName, interfaceName string) (CatalogEndpoint, error) {
-	var serviceEntry CatalogEntry
-	for _, entry := range catalogEntries {
-		if entry.Type == "network" {
-			serviceEntry = entry
-			break
-		}
-	}
-	if serviceEntry.Type == "" {
-		return CatalogEndpoint{}, fmt.Errorf("service type %s not found in catalog", serviceType)
-	}
-	var serviceEndpoint CatalogEndpoint
-	for _, endpoint := range serviceEntry.Endpoints {
-		if endpoint.Region == regionName && endpoint.Interface == interfaceName {
-			serviceEndpoint = endpoint
-			break
-		}
-	}
-	if serviceEndpoint.Region == "" {
-		return CatalogEndpoint{}, fmt.Errorf("service catalog for %s does not have endpoint for region %s", serviceEntry.Name, regionName)
-	}
-	return serviceEndpoint, nil
+// Project is a project in Identity (Keystone) API
+type Project struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	DomainID string `json:"domain_id" mapstructure:"domain_id"`
+	Enabled  bool   `json:"enabled"`
 }
 
-func makeRequest(httpMethod string, url string, token string, body io.Reader, successStatusCodes []int) (*http.Response, error) {
-	req, err := http.NewRequest(httpMethod, url, body)
+func makeRequest(ctx context.Context, client *http.Client, retryPolicy RetryPolicy, userAgent string, httpMethod string, url string, token string, body io.Reader, successStatusCodes []int) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, httpMethod, url, body)
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("X-Auth-Token", token)
-	resp, err := makeHTTPRequestWithRetry(req)
+	if userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+	resp, err := makeHTTPRequestWithRetry(ctx, client, retryPolicy, req)
 	if err != nil {
 		return resp, err
 	}
@@ -229,31 +222,36 @@ func makeRequest(httpMethod string, url string, token string, body io.Reader, su
 		return nil, err
 	}
 	defer resp.Body.Close()
-	return resp, fmt.Errorf("%s, %s, %s", resp.Status, url, buf.String())
+	return resp, newAPIError(resp, httpMethod, url, buf.Bytes())
 }
 
-func makeHTTPRequestWithRetry(req *http.Request) (*http.Response, error) {
-	var resp *http.Response
-	var err error
-	const maxRetryCount = 3
-
-	client := getHTTPClient()
-	for i := 0; i < maxRetryCount; i++ {
-		resp, err = client.Do(req)
-		if err != nil {
-			return nil, err
+// makeHTTPRequestWithRetry makes the request, and retries on transient failures according to the retry policy.
+// Response of the last attempt is returned if all attempts failed with a retryable status code.
+func makeHTTPRequestWithRetry(ctx context.Context, client *http.Client, retryPolicy RetryPolicy, req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := client.Do(req)
+		if attempt >= retryPolicy.MaxRetries || !shouldRetry(resp, err) {
+			return resp, err
+		}
+		nextReq, ok := rewindRequest(req)
+		if !ok {
+			// body cannot be re-sent
+			return resp, err
 		}
-		if resp.StatusCode >= 400 {
-			time.Sleep(time.Millisecond * 500 * time.Duration(i)) // exp backoff
-			continue
+		wait := retryPolicy.backoff(attempt, resp)
+		if resp != nil {
+			// discard the response of this attempt, so that the connection can be reused
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
 		}
-		break
+		// abort if cancelled
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		req = nextReq
 	}
-	return resp, err
-}
-
-func getHTTPClient() *http.Client {
-	return &http.Client{Timeout: 5 * time.Second}
 }
 
 // CatalogEntry is an entry of Catalog, it contains metadata for an OpenStack service
@@ -269,5 +267,6 @@ type CatalogEndpoint struct {
 	ID        string `json:"id"`
 	Interface string `json:"interface"`
 	Region    string `json:"region"`
+	RegionID  string `json:"region_id"`
 	URL       string `json:"url"`
 }
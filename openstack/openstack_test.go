@@ -0,0 +1,750 @@
+package openstack_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/cyverse/openstack-auto-allocated-topology/openstack"
+	"gitlab.com/cyverse/openstack-auto-allocated-topology/openstack/fake"
+)
+
+func appCredential(server *fake.Server) openstack.CredentialEnv {
+	return openstack.CredentialEnv{
+		AuthURL:                     server.AuthURL(),
+		RegionName:                  fake.RegionName,
+		Interface:                   "public",
+		ApplicationCredentialID:     fake.ApplicationCredentialID,
+		ApplicationCredentialSecret: fake.ApplicationCredentialSecret,
+	}
+}
+
+func TestResolveCredential(t *testing.T) {
+	cloudsFile := filepath.Join(t.TempDir(), "clouds.yaml")
+	err := os.WriteFile(cloudsFile, []byte(`clouds:
+  one:
+    auth:
+      auth_url: https://one.example/identity
+  two:
+    auth:
+      auth_url: https://two.example/identity
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("OS_CLIENT_CONFIG_FILE", cloudsFile)
+	t.Setenv("OS_CLIENT_SECURE_FILE", cloudsFile)
+	t.Setenv("OS_CLOUD", "one")
+	t.Setenv("OS_AUTH_URL", "")
+	t.Setenv("OS_REGION_NAME", "")
+
+	testCases := []struct {
+		cloud   string
+		authURL string
+	}{
+		{"", "https://one.example/identity"},
+		{"two", "https://two.example/identity"},
+	}
+	for _, tc := range testCases {
+		cred, err := openstack.ResolveCredential(tc.cloud)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cred.AuthURL != tc.authURL || cred.Cloud == "" {
+			t.Errorf("cloud %q: expected auth URL %s, got %+v", tc.cloud, tc.authURL, cred)
+		}
+	}
+
+	// environment variables that are set take priority over clouds.yaml, as openstackclient
+	t.Setenv("OS_AUTH_URL", "https://env.example/identity")
+	t.Setenv("OS_REGION_NAME", "RegionTwo")
+	cred, err := openstack.ResolveCredential("two")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cred.AuthURL != "https://env.example/identity" || cred.RegionName != "RegionTwo" || cred.Cloud != "two" {
+		t.Errorf("expected environment variables over clouds.yaml, got %+v", cred)
+	}
+
+	t.Setenv("OS_CLOUD", "")
+	cred, err = openstack.ResolveCredential("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cred.AuthURL != "https://env.example/identity" || cred.Cloud != "" {
+		t.Errorf("expected credential from environment variables, got %+v", cred)
+	}
+}
+
+// newClient authenticates with the fake server, retries are quick so that tests with injected faults are fast
+func newClient(t *testing.T, server *fake.Server, cred openstack.CredentialEnv) *openstack.Client {
+	t.Helper()
+	client := openstack.NewClient()
+	client.SetRetryPolicy(openstack.RetryPolicy{MaxRetries: 3, MaxBackoff: 10 * time.Millisecond})
+	err := client.Auth(context.Background(), cred)
+	if err != nil {
+		t.Fatalf("fail to authenticate, %s", err)
+	}
+	return client
+}
+
+func TestAuthApplicationCredential(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	client := newClient(t, server, appCredential(server))
+	if client.AuthMethod() != openstack.AuthMethodApplicationCredentialID {
+		t.Errorf("expected %s, got %s", openstack.AuthMethodApplicationCredentialID, client.AuthMethod())
+	}
+	projectID, projectName := client.CurrentProject()
+	if projectID != fake.ProjectID || projectName != fake.ProjectName {
+		t.Errorf("expected project %s (%s), got %s (%s)", fake.ProjectID, fake.ProjectName, projectID, projectName)
+	}
+}
+
+func TestAuthPasswordScopedByProjectName(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	client := newClient(t, server, openstack.CredentialEnv{
+		AuthURL:           server.AuthURL(),
+		Username:          fake.Username,
+		Password:          fake.Password,
+		UserDomainName:    fake.DomainName,
+		ProjectName:       "fake-other-project",
+		ProjectDomainName: fake.DomainName,
+	})
+	if client.AuthMethod() != openstack.AuthMethodPassword {
+		t.Errorf("expected %s, got %s", openstack.AuthMethodPassword, client.AuthMethod())
+	}
+	projectID, _ := client.CurrentProject()
+	if projectID != "fake-other-project-id" {
+		t.Errorf("expected project fake-other-project-id, got %s", projectID)
+	}
+}
+
+func TestAuthWrongSecret(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	cred := appCredential(server)
+	cred.ApplicationCredentialSecret = "wrong"
+	err := openstack.NewClient().Auth(context.Background(), cred)
+	if err == nil {
+		t.Fatal("expected error for wrong secret")
+	}
+}
+
+func TestTopologyLifecycle(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+
+	client := newClient(t, server, appCredential(server))
+	networkClient, err := client.Network(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	topology, err := networkClient.FindAutoAllocatedTopology(ctx, fake.ProjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topology != nil {
+		t.Fatalf("expected no topology before creation, got %+v", topology)
+	}
+
+	topology, err = networkClient.GetAutoAllocatedTopology(ctx, fake.ProjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topology.NetworkID != server.TopologyNetworkID(fake.ProjectID) {
+		t.Errorf("expected network %s, got %s", server.TopologyNetworkID(fake.ProjectID), topology.NetworkID)
+	}
+	err = networkClient.GetAutoAllocatedTopologyDetails(ctx, topology)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topology.Name != "auto_allocated_network" || topology.MTU != 1450 || len(topology.Subnets) != 1 || topology.RouterID == "" {
+		t.Errorf("details are not filled in, %+v", topology)
+	}
+	if count := server.RequestCount("GET", "/network/v2.0/networks/"+topology.NetworkID); count != 1 {
+		t.Errorf("expected network to be fetched once for name and MTU, got %d", count)
+	}
+	if topology.ExternalNetworkID != fake.ExternalNetworkID || len(topology.ExternalFixedIPs) != 1 {
+		t.Errorf("external gateway is not filled in, %+v", topology)
+	}
+
+	found, err := networkClient.FindAutoAllocatedTopology(ctx, fake.ProjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found == nil || found.NetworkID != topology.NetworkID {
+		t.Errorf("expected to find network %s, got %+v", topology.NetworkID, found)
+	}
+
+	err = networkClient.DeleteAutoAllocatedTopology(ctx, fake.ProjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.TopologyNetworkID(fake.ProjectID) != "" {
+		t.Error("topology is not deleted")
+	}
+}
+
+func TestFindTopologyLookAlike(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+
+	client := newClient(t, server, appCredential(server))
+	networkClient, err := client.Network(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// network created by user with the same name as the topology network
+	server.AddRoutedNetwork(fake.ProjectID, "auto_allocated_network", "my-router")
+	found, err := networkClient.FindAutoAllocatedTopology(ctx, fake.ProjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != nil {
+		t.Fatalf("expected look-alike network not to be the topology, got %+v", found)
+	}
+
+	topology, err := networkClient.GetAutoAllocatedTopology(ctx, fake.ProjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, err = networkClient.FindAutoAllocatedTopology(ctx, fake.ProjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found == nil || found.NetworkID != topology.NetworkID {
+		t.Errorf("expected to find network %s, got %+v", topology.NetworkID, found)
+	}
+
+	// router created by user with the same name as the topology router, on another network
+	server.AddRoutedNetwork(fake.ProjectID, "other", "auto_allocated_router")
+	_, err = networkClient.FindAutoAllocatedTopology(ctx, fake.ProjectID)
+	if err == nil || !strings.Contains(err.Error(), "more than one network") {
+		t.Errorf("expected error for ambiguous topology, got %v", err)
+	}
+}
+
+func TestEndpointResolvedOnce(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+
+	client := newClient(t, server, appCredential(server))
+	for i := 0; i < 3; i++ {
+		_, err := client.Network(ctx, fake.RegionName)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count := server.RequestCount(http.MethodGet, "/identity/v3/auth/catalog"); count != 0 {
+		t.Errorf("expected catalog from token, got %d catalog requests", count)
+	}
+	if count := server.RequestCount(http.MethodGet, "/network/"); count != 1 {
+		t.Errorf("expected version discovery once, got %d", count)
+	}
+}
+
+func TestEndpointNotInCatalog(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	client := newClient(t, server, appCredential(server))
+	_, err := client.Network(context.Background(), "RegionTwo")
+	if err == nil {
+		t.Fatal("expected error for region not in catalog")
+	}
+}
+
+func TestEndpointCatalogVariants(t *testing.T) {
+	tests := []struct {
+		name           string
+		networkCatalog fake.NetworkCatalog
+		discovery      int
+	}{
+		{"service type alias", fake.NetworkCatalog{ServiceType: "networking"}, 1},
+		{"region_id only", fake.NetworkCatalog{RegionIDOnly: true}, 1},
+		{"versioned URL", fake.NetworkCatalog{VersionedURL: true}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := fake.NewServer()
+			defer server.Close()
+			ctx := context.Background()
+			server.SetNetworkCatalog(tt.networkCatalog)
+
+			client := newClient(t, server, appCredential(server))
+			networkClient, err := client.Network(ctx, fake.RegionName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = networkClient.GetAutoAllocatedTopology(ctx, fake.ProjectID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count := server.RequestCount(http.MethodGet, "/network/"); count != tt.discovery {
+				t.Errorf("expected %d version discovery, got %d", tt.discovery, count)
+			}
+		})
+	}
+}
+
+func TestEndpointRegionNotConfigured(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+	credEnv := appCredential(server)
+	credEnv.RegionName = ""
+
+	// single region in catalog is used
+	client := newClient(t, server, credEnv)
+	_, err := client.Network(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.SetNetworkCatalog(fake.NetworkCatalog{ExtraRegions: []string{"RegionTwo"}})
+	client = newClient(t, server, credEnv)
+	_, err = client.Network(ctx, "")
+	if err == nil || !strings.Contains(err.Error(), "more than one region") {
+		t.Errorf("expected error for region not configured in multi-region catalog, got %v", err)
+	}
+	_, err = client.Network(ctx, "RegionTwo")
+	if err != nil {
+		t.Errorf("expected configured region to be found, got %v", err)
+	}
+}
+
+func TestEndpointConcurrentDiscovery(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	client := newClient(t, server, appCredential(server))
+	server.InjectFault(fake.Fault{Method: http.MethodGet, PathPrefix: "/network/", Latency: 200 * time.Millisecond, Count: 1})
+
+	// discovery of a caller whose context ends is retried by the other callers
+	leaderCtx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	leaderDone := make(chan error, 1)
+	go func() {
+		_, err := client.Network(leaderCtx, "")
+		leaderDone <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	errs := make(chan error, 5)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_, err := client.Network(context.Background(), "")
+			errs <- err
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+	if err := <-leaderDone; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded for the canceled caller, got %v", err)
+	}
+	if count := server.RequestCount(http.MethodGet, "/network/"); count != 2 {
+		t.Errorf("expected the canceled discovery to be retried once, got %d", count)
+	}
+}
+
+func TestEndpointOverride(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+
+	client := openstack.NewClient()
+	client.SetEndpointConfig(openstack.EndpointConfig{
+		Overrides: map[string]string{"network": server.NetworkURL() + "/v2.0/"},
+	})
+	err := client.Auth(ctx, appCredential(server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// override is used regardless of region
+	networkClient, err := client.Network(ctx, "RegionTwo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = networkClient.GetAutoAllocatedTopology(ctx, fake.ProjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := server.RequestCount(http.MethodGet, "/network/"); count != 0 {
+		t.Errorf("expected no version discovery for versioned URL, got %d", count)
+	}
+}
+
+func TestIdentityEndpointInterface(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+
+	client := openstack.NewClient()
+	client.SetEndpointConfig(openstack.EndpointConfig{
+		Interfaces: map[string]string{"identity": "internal"},
+	})
+	err := client.Auth(ctx, appCredential(server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	projectID, err := client.LookupProjectByName(ctx, fake.ProjectName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if projectID != fake.ProjectID {
+		t.Errorf("expected %s, got %s", fake.ProjectID, projectID)
+	}
+	path := "/users/" + fake.UserID + "/projects"
+	if count := server.RequestCount(http.MethodGet, "/internal/identity/v3"+path); count != 1 {
+		t.Errorf("expected projects to be listed from the internal endpoint, got %d", count)
+	}
+	if count := server.RequestCount(http.MethodGet, "/identity/v3"+path); count != 0 {
+		t.Errorf("expected no request to the auth URL, got %d", count)
+	}
+}
+
+func TestRetryOnServerError(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+
+	client := newClient(t, server, appCredential(server))
+	networkClient, err := client.Network(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := "/network/v2.0/auto-allocated-topology/" + fake.ProjectID
+	server.InjectFault(fake.Fault{PathPrefix: path, StatusCode: http.StatusServiceUnavailable, Count: 2})
+	_, err = networkClient.GetAutoAllocatedTopology(ctx, fake.ProjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := server.RequestCount(http.MethodGet, path); count != 3 {
+		t.Errorf("expected 3 attempts, got %d", count)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+
+	client := newClient(t, server, appCredential(server))
+	networkClient, err := client.Network(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := "/network/v2.0/auto-allocated-topology/" + fake.ProjectID
+	server.InjectFault(fake.Fault{PathPrefix: path, StatusCode: http.StatusInternalServerError})
+	_, err = networkClient.GetAutoAllocatedTopology(ctx, fake.ProjectID)
+	var apiErr openstack.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("expected 500 APIError, got %v", err)
+	}
+	if count := server.RequestCount(http.MethodGet, path); count != 4 {
+		t.Errorf("expected 4 attempts, got %d", count)
+	}
+}
+
+func TestNoRetryOnClientError(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+
+	client := newClient(t, server, appCredential(server))
+	networkClient, err := client.Network(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := "/network/v2.0/auto-allocated-topology/" + fake.ProjectID
+	server.InjectFault(fake.Fault{PathPrefix: path, StatusCode: http.StatusForbidden})
+	_, err = networkClient.GetAutoAllocatedTopology(ctx, fake.ProjectID)
+	var forbiddenErr openstack.ForbiddenError
+	if !errors.As(err, &forbiddenErr) {
+		t.Fatalf("expected ForbiddenError, got %v", err)
+	}
+	if forbiddenErr.RequestID == "" {
+		t.Error("expected request ID in error")
+	}
+	if count := server.RequestCount(http.MethodGet, path); count != 1 {
+		t.Errorf("expected 1 attempt, got %d", count)
+	}
+}
+
+func TestLatencyExceedsDeadline(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	client := newClient(t, server, appCredential(server))
+	networkClient, err := client.Network(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.InjectFault(fake.Fault{PathPrefix: "/network/v2.0/auto-allocated-topology/", Latency: time.Second})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = networkClient.GetAutoAllocatedTopology(ctx, fake.ProjectID)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestAuthHonorsContext(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	server.InjectFault(fake.Fault{PathPrefix: "/identity/v3/auth/tokens", Latency: time.Second})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := openstack.NewClient().Auth(ctx, appCredential(server))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected authentication to be cancelled, took %s", elapsed)
+	}
+}
+
+func TestReauthHonorsContext(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	client := newClient(t, server, appCredential(server))
+	networkClient, err := client.Network(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.RevokeTokens()
+	server.InjectFault(fake.Fault{PathPrefix: "/identity/v3/auth/tokens", Latency: time.Second})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = networkClient.GetAutoAllocatedTopology(ctx, fake.ProjectID)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	// token is renewed by the next request
+	server.ClearFaults()
+	_, err = networkClient.GetAutoAllocatedTopology(context.Background(), fake.ProjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPrerequisiteNotMet(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+	server.SetDefaultExternalNetwork(false)
+
+	client := newClient(t, server, appCredential(server))
+	networkClient, err := client.Network(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = networkClient.ValidateAutoAllocatedTopology(ctx, fake.ProjectID)
+	var prerequisiteErr openstack.AutoAllocationPrerequisiteError
+	if !errors.As(err, &prerequisiteErr) {
+		t.Fatalf("expected AutoAllocationPrerequisiteError, got %v", err)
+	}
+	_, err = networkClient.GetAutoAllocatedTopology(ctx, fake.ProjectID)
+	if !errors.As(err, &prerequisiteErr) {
+		t.Fatalf("expected AutoAllocationPrerequisiteError, got %v", err)
+	}
+
+	server.SetDefaultExternalNetwork(true)
+	err = networkClient.ValidateAutoAllocatedTopology(ctx, fake.ProjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.TopologyNetworkID(fake.ProjectID) != "" {
+		t.Error("dry-run should not create topology")
+	}
+
+	// dry-run checks the prerequisites even if the project already has a topology
+	_, err = networkClient.GetAutoAllocatedTopology(ctx, fake.ProjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetDefaultExternalNetwork(false)
+	err = networkClient.ValidateAutoAllocatedTopology(ctx, fake.ProjectID)
+	if !errors.As(err, &prerequisiteErr) {
+		t.Fatalf("expected AutoAllocationPrerequisiteError with existing topology, got %v", err)
+	}
+	_, err = networkClient.GetAutoAllocatedTopology(ctx, fake.ProjectID)
+	if err != nil {
+		t.Errorf("expected existing topology to be returned, got %v", err)
+	}
+}
+
+func TestExtensionMissing(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+	server.SetAutoAllocation(false)
+
+	client := newClient(t, server, appCredential(server))
+	networkClient, err := client.Network(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = networkClient.GetAutoAllocatedTopology(ctx, fake.ProjectID)
+	var extensionErr openstack.ExtensionMissingError
+	if !errors.As(err, &extensionErr) {
+		t.Fatalf("expected ExtensionMissingError, got %v", err)
+	}
+	// delete is not treated as already deleted
+	err = networkClient.DeleteAutoAllocatedTopology(ctx, fake.ProjectID)
+	if !errors.As(err, &extensionErr) {
+		t.Fatalf("expected ExtensionMissingError on delete, got %v", err)
+	}
+}
+
+func TestDeleteMissingTopology(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+
+	client := newClient(t, server, appCredential(server))
+	networkClient, err := client.Network(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Neutron responds 204 for a project without a topology
+	err = networkClient.DeleteAutoAllocatedTopology(ctx, fake.ProjectID)
+	if err != nil {
+		t.Fatalf("expected deleting a missing topology to succeed, got %v", err)
+	}
+}
+
+func TestReauthOnRejectedToken(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+
+	client := newClient(t, server, appCredential(server))
+	networkClient, err := client.Network(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.RevokeTokens()
+	_, err = networkClient.GetAutoAllocatedTopology(ctx, fake.ProjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// gophercloud requests re-authenticate as well
+	server.RevokeTokens()
+	_, err = client.LookupProjectByName(ctx, fake.ProjectName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := server.RequestCount(http.MethodPost, "/identity/v3/auth/tokens"); count != 3 {
+		t.Errorf("expected 3 token requests, got %d", count)
+	}
+}
+
+func TestRenewExpiringToken(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+	// refresh margin is half of the lifetime of token
+	server.SetTokenTTL(400 * time.Millisecond)
+
+	client := newClient(t, server, appCredential(server))
+	networkClient, err := client.Network(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(250 * time.Millisecond)
+	_, err = networkClient.FindAutoAllocatedTopology(ctx, fake.ProjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := server.RequestCount(http.MethodPost, "/identity/v3/auth/tokens"); count < 2 {
+		t.Errorf("expected token to be renewed, got %d token requests", count)
+	}
+}
+
+func TestShortLivedTokenNotRenewedEveryRequest(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+	// shorter than tokenRefreshMargin
+	server.SetTokenTTL(2 * time.Minute)
+
+	client := newClient(t, server, appCredential(server))
+	networkClient, err := client.Network(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		_, err = networkClient.FindAutoAllocatedTopology(ctx, fake.ProjectID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count := server.RequestCount(http.MethodPost, "/identity/v3/auth/tokens"); count != 1 {
+		t.Errorf("expected token not to be renewed, got %d token requests", count)
+	}
+}
+
+func TestTokenAuthCannotRenew(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+
+	client := newClient(t, server, openstack.CredentialEnv{
+		AuthURL: server.AuthURL(),
+		Token:   server.IssueToken(),
+	})
+	if client.AuthMethod() != openstack.AuthMethodToken {
+		t.Errorf("expected %s, got %s", openstack.AuthMethodToken, client.AuthMethod())
+	}
+	networkClient, err := client.Network(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.RevokeTokens()
+	_, err = networkClient.FindAutoAllocatedTopology(ctx, fake.ProjectID)
+	if err == nil {
+		t.Fatal("expected error since pre-issued token cannot be renewed")
+	}
+}
+
+func TestLookupProjectByName(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddProject("fake-third-project-id", "fake-third-project")
+
+	client := newClient(t, server, appCredential(server))
+	id, err := client.LookupProjectByName(context.Background(), "fake-third-project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "fake-third-project-id" {
+		t.Errorf("expected fake-third-project-id, got %s", id)
+	}
+	_, err = client.LookupProjectByName(context.Background(), "no-such-project")
+	if err == nil {
+		t.Error("expected error for unknown project")
+	}
+}
@@ -0,0 +1,101 @@
+package openstack
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy controls how requests to OpenStack API are retried.
+// Only transient failures are retried: 5xx, 429 and connection reset.
+type RetryPolicy struct {
+	MaxRetries int           // max number of retries after the initial attempt
+	MaxBackoff time.Duration // upper bound of the wait between attempts, including wait requested by Retry-After
+}
+
+// DefaultRetryPolicy returns the retry policy used when none is configured
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries: 3,
+		MaxBackoff: 30 * time.Second,
+	}
+}
+
+// base of the exponential backoff
+const retryBaseBackoff = 500 * time.Millisecond
+
+// shouldRetry checks whether a request should be retried based on the response or error of the attempt
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return isConnectionReset(err)
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// isConnectionReset checks if the error is connection being reset or closed by server (e.g. a stale keep-alive connection)
+func isConnectionReset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// backoff returns how long to wait before the next attempt.
+// Retry-After from the response is honoured if present, otherwise it is exponential backoff with jitter.
+// Both are capped at MaxBackoff.
+func (p RetryPolicy) backoff(attempt int, resp *http.Response) time.Duration {
+	if retryAfter, ok := parseRetryAfter(resp); ok {
+		if retryAfter > p.MaxBackoff {
+			return p.MaxBackoff
+		}
+		return retryAfter
+	}
+	wait := retryBaseBackoff << uint(attempt)
+	if wait <= 0 || wait > p.MaxBackoff {
+		// wait <= 0 if overflow
+		wait = p.MaxBackoff
+	}
+	// equal jitter, wait between half and full of the backoff
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+// parseRetryAfter parses the Retry-After header, which is either seconds or an HTTP date
+func parseRetryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		wait := time.Until(date)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
+
+// rewindRequest prepares the request for another attempt, the body is re-created since it could be consumed by the previous attempt.
+// false is returned if the body cannot be re-created.
+func rewindRequest(req *http.Request) (*http.Request, bool) {
+	newReq := req.Clone(req.Context())
+	if req.Body == nil || req.Body == http.NoBody {
+		return newReq, true
+	}
+	if req.GetBody == nil {
+		return nil, false
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, false
+	}
+	newReq.Body = body
+	return newReq, true
+}
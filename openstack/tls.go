@@ -0,0 +1,44 @@
+package openstack
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// newTLSConfig creates TLS config based on the CA bundle, client certificate and insecure mode in the credential.
+// nil is returned if none of them is specified, which means the default TLS config is used.
+func newTLSConfig(cred CredentialEnv) (*tls.Config, error) {
+	if cred.CACertFile == "" && cred.Cert == "" && cred.Key == "" && !cred.Insecure {
+		return nil, nil
+	}
+	config := &tls.Config{
+		InsecureSkipVerify: cred.Insecure,
+	}
+	if cred.CACertFile != "" {
+		caCert, err := os.ReadFile(cred.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("fail to read CA bundle %s, %w", cred.CACertFile, err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no PEM certificate found in CA bundle %s", cred.CACertFile)
+		}
+		config.RootCAs = pool
+	}
+	if cred.Cert != "" || cred.Key != "" {
+		if cred.Cert == "" || cred.Key == "" {
+			return nil, fmt.Errorf("client certificate and key must be specified together")
+		}
+		clientCert, err := tls.LoadX509KeyPair(cred.Cert, cred.Key)
+		if err != nil {
+			return nil, fmt.Errorf("fail to load client certificate, %w", err)
+		}
+		config.Certificates = []tls.Certificate{clientCert}
+	}
+	return config, nil
+}
@@ -0,0 +1,122 @@
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"time"
+)
+
+// token is renewed proactively if it expires within this margin, so that a long request does not fail mid-way.
+// The margin is capped at half of the token lifetime, see refreshMargin().
+const tokenRefreshMargin = 5 * time.Minute
+
+// refreshMargin returns how long before expiry the token is renewed, a token that lives no longer than
+// tokenRefreshMargin would otherwise be renewed on every request
+func (metadata TokenMetadata) refreshMargin() time.Duration {
+	if metadata.IssuedAt.IsZero() {
+		return tokenRefreshMargin
+	}
+	lifetime := metadata.ExpiresAt.Sub(metadata.IssuedAt)
+	if lifetime/2 < tokenRefreshMargin {
+		return lifetime / 2
+	}
+	return tokenRefreshMargin
+}
+
+// canReauth checks if the method can obtain a new token when the current one expires,
+// a pre-issued token cannot be renewed.
+func (method AuthMethod) canReauth() bool {
+	return method != AuthMethodToken
+}
+
+func (c *Client) getTokenMetadata() TokenMetadata {
+	c.tokenLock.RLock()
+	defer c.tokenLock.RUnlock()
+	return c.tokenMetadata
+}
+
+func (c *Client) setTokenMetadata(metadata TokenMetadata) {
+	c.tokenLock.Lock()
+	defer c.tokenLock.Unlock()
+	c.tokenMetadata = metadata
+	// catalog could change with the new token
+	c.endpointCache = nil
+}
+
+// currentToken returns the current token, the token is renewed first if it is about to expire.
+func (c *Client) currentToken(ctx context.Context) (string, error) {
+	token := c.provider.Token()
+	metadata := c.getTokenMetadata()
+	expiresAt := metadata.ExpiresAt
+	if expiresAt.IsZero() || time.Until(expiresAt) > metadata.refreshMargin() || !c.authMethod.canReauth() {
+		return token, nil
+	}
+	return c.reauthenticate(ctx, token)
+}
+
+// reauthenticate obtains a new token and returns it. previousToken is the token that is found to be expiring or rejected,
+// re-authentication is skipped if the token has already been renewed by another request.
+// The request for token is made with ctx, so it is cancelled with the request that needs the token.
+func (c *Client) reauthenticate(ctx context.Context, previousToken string) (string, error) {
+	if !c.authMethod.canReauth() {
+		return "", fmt.Errorf("token is expired or rejected, and cannot be renewed when authenticated using %s", c.authMethod)
+	}
+	c.reauthLock.Lock()
+	defer c.reauthLock.Unlock()
+	if token := c.provider.Token(); token != previousToken {
+		// renewed by another request while waiting for the lock
+		return token, nil
+	}
+	provider := &gophercloud.ProviderClient{
+		IdentityBase:     c.provider.IdentityBase,
+		IdentityEndpoint: c.provider.IdentityEndpoint,
+		HTTPClient:       c.provider.HTTPClient,
+		UserAgent:        c.provider.UserAgent,
+		Context:          ctx,
+	}
+	err := openstack.AuthenticateV3(provider, &c.authOptions, gophercloud.EndpointOpts{})
+	if err != nil {
+		return "", fmt.Errorf("fail to re-authenticate using %s, %w", c.authMethod, err)
+	}
+	token, metadata, err := obtainToken(provider)
+	if err != nil {
+		return "", fmt.Errorf("fail to re-authenticate using %s, %w", c.authMethod, err)
+	}
+	c.provider.CopyTokenFrom(provider)
+	c.setTokenMetadata(metadata)
+	tflog.Debug(ctx, "renewed OpenStack token", map[string]interface{}{
+		"auth_method":      string(c.authMethod),
+		"token_expires_at": metadata.ExpiresAt.String(),
+	})
+	return token, nil
+}
+
+// providerWithContext returns a copy of the gophercloud provider client that uses ctx for its requests.
+// The copy has its own token lock, and re-authenticates through the original provider,
+// so that the renewed token is shared with all other requests.
+func (c *Client) providerWithContext(ctx context.Context) *gophercloud.ProviderClient {
+	provider := &gophercloud.ProviderClient{
+		IdentityBase:     c.provider.IdentityBase,
+		IdentityEndpoint: c.provider.IdentityEndpoint,
+		EndpointLocator:  c.provider.EndpointLocator,
+		HTTPClient:       c.provider.HTTPClient,
+		UserAgent:        c.provider.UserAgent,
+		Context:          ctx,
+	}
+	provider.UseTokenLock()
+	provider.CopyTokenFrom(c.provider)
+	if c.authMethod.canReauth() {
+		provider.ReauthFunc = func() error {
+			_, err := c.reauthenticate(ctx, provider.Token())
+			if err != nil {
+				return err
+			}
+			provider.CopyTokenFrom(c.provider)
+			return nil
+		}
+	}
+	return provider
+}
@@ -0,0 +1,81 @@
+package openstack
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// settings of the connection pool, one pool is shared by all requests of a provider instance,
+// so that connections and TLS sessions are reused across resources
+const (
+	maxIdleConns        = 100
+	maxIdleConnsPerHost = 32 // default is 2, which is too low for many concurrent requests to the same endpoint
+	idleConnTimeout     = 90 * time.Second
+)
+
+// defaultRequestTimeout bounds a request whose context has no deadline (e.g. in the CLI),
+// so that an endpoint that accepts the connection but never responds does not hang forever.
+// It is long enough for Neutron to create a topology, which is done within the request.
+const defaultRequestTimeout = 5 * time.Minute
+
+// newHTTPClient creates the client used for all OpenStack API calls, both by gophercloud and by raw requests.
+// There is no timeout on the client, deadline of requests comes from the context (timeouts of resource and data source),
+// or defaultRequestTimeout if the context has none.
+func newHTTPClient(cred CredentialEnv) (*http.Client, error) {
+	tlsConfig, err := newTLSConfig(cred)
+	if err != nil {
+		return nil, err
+	}
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          maxIdleConns,
+		MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+		IdleConnTimeout:       idleConnTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       tlsConfig,
+	}
+	return &http.Client{Transport: &loggingTransport{base: &deadlineTransport{base: transport, timeout: defaultRequestTimeout}}}, nil
+}
+
+// deadlineTransport applies a timeout to requests whose context has no deadline
+type deadlineTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+// RoundTrip ...
+func (t *deadlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if _, ok := req.Context().Deadline(); ok {
+		return t.base.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// the deadline also covers reading the body, it is released when the body is closed
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close ...
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,617 @@
+package provider
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+	"gitlab.com/cyverse/openstack-auto-allocated-topology/openstack"
+)
+
+const (
+	projectIDsAttribute         = "project_ids"
+	projectNamesAttribute       = "project_names"
+	parallelismAttribute        = "parallelism"
+	networkIDsAttribute         = "network_ids"
+	resolvedProjectIDsAttribute = "resolved_project_ids"
+	failuresAttribute           = "failures"
+)
+
+const defaultParallelism = 5
+
+func resourceAutoAllocatedTopologies() *schema.Resource {
+	return &schema.Resource{
+		Description: "Use this resource to manage the auto allocated topologies of many projects at once. " +
+			"Topologies are created (and deleted) in parallel, a project that fails does not fail the others, it is reported in failures and retried on the next apply. " +
+			"The failure of a project in project_ids, or of every project, is an error, the failure of a project in project_names is a warning.",
+		CreateContext: resourceAutoAllocatedTopologiesCreate,
+		ReadContext:   resourceAutoAllocatedTopologiesRead,
+		UpdateContext: resourceAutoAllocatedTopologiesUpdate,
+		DeleteContext: resourceAutoAllocatedTopologiesDelete,
+		CustomizeDiff: resourceAutoAllocatedTopologiesCustomizeDiff,
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(30 * time.Minute),
+			Read:   schema.DefaultTimeout(10 * time.Minute),
+			Update: schema.DefaultTimeout(30 * time.Minute),
+			Delete: schema.DefaultTimeout(30 * time.Minute),
+		},
+		SchemaVersion: 1,
+		Schema:        resourceAutoAllocatedTopologiesSchema(),
+	}
+}
+
+func resourceAutoAllocatedTopologiesSchema() map[string]*schema.Schema {
+	return map[string]*schema.Schema{
+		projectIDsAttribute: {
+			Type:         schema.TypeSet,
+			Optional:     true,
+			Description:  "IDs of the projects to create the auto allocated topology for",
+			Elem:         &schema.Schema{Type: schema.TypeString, ValidateFunc: validation.StringIsNotEmpty},
+			AtLeastOneOf: []string{projectIDsAttribute, projectNamesAttribute},
+		},
+		projectNamesAttribute: {
+			Type:         schema.TypeSet,
+			Optional:     true,
+			Description:  "names of the projects to create the auto allocated topology for",
+			Elem:         &schema.Schema{Type: schema.TypeString, ValidateFunc: validation.StringIsNotEmpty},
+			AtLeastOneOf: []string{projectIDsAttribute, projectNamesAttribute},
+		},
+		regionNameAttribute: {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Computed:    true,
+			ForceNew:    true,
+			Description: "region name of the auto allocated topologies, defaults to the region of the provider",
+		},
+		parallelismAttribute: {
+			Type:         schema.TypeInt,
+			Optional:     true,
+			Default:      defaultParallelism,
+			ValidateFunc: validation.IntBetween(1, 50),
+			Description:  "max number of projects to create (or delete) the topology for at the same time",
+		},
+		networkIDsAttribute: {
+			Type:        schema.TypeMap,
+			Computed:    true,
+			Elem:        &schema.Schema{Type: schema.TypeString},
+			Description: "network ID of the topology of each project that succeeded, keyed by project ID or name as specified, a project specified by both ID and name has one topology",
+		},
+		resolvedProjectIDsAttribute: {
+			Type:        schema.TypeMap,
+			Computed:    true,
+			Elem:        &schema.Schema{Type: schema.TypeString},
+			Description: "project ID of each project, keyed by project ID or name as specified",
+		},
+		failuresAttribute: {
+			Type:        schema.TypeMap,
+			Computed:    true,
+			Elem:        &schema.Schema{Type: schema.TypeString},
+			Description: "error of each project that failed, keyed by project ID or name as specified, failed projects are retried on the next apply",
+		},
+	}
+}
+
+// topologiesState is the outcome of each project, keyed by project ID or name as specified.
+// Topologies are created and deleted by the resolved project ID, so keys that resolve to the same project share its topology.
+type topologiesState struct {
+	networkIDs map[string]string
+	projectIDs map[string]string
+	failures   map[string]string
+}
+
+func newTopologiesState() topologiesState {
+	return topologiesState{
+		networkIDs: map[string]string{},
+		projectIDs: map[string]string{},
+		failures:   map[string]string{},
+	}
+}
+
+// desiredProjects returns the projects in config, the value is true if the key is a project name
+type desiredProjects map[string]bool
+
+func resourceAutoAllocatedTopologiesCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	// from return value of providerConfigure()
+	osClient := m.(openstack.IdentityAPI)
+	regionName := getRegionName(d, osClient)
+
+	networkClient, err := osClient.Network(ctx, regionName)
+	if err != nil {
+		return addErrorDiagnostic(diags, err)
+	}
+	id, err := newTopologiesID()
+	if err != nil {
+		return addErrorDiagnostic(diags, err)
+	}
+	err = d.Set(regionNameAttribute, regionName)
+	if err != nil {
+		return addErrorDiagnostic(diags, err)
+	}
+
+	state, diags := applyTopologies(ctx, osClient, networkClient, newTopologiesState(), getDesiredProjects(d), d.Get(parallelismAttribute).(int))
+	if len(state.networkIDs) == 0 && diags.HasError() {
+		// nothing is created, no state to store
+		return diags
+	}
+	// ID is set even if some projects failed, so that the topologies that succeeded are tracked in state
+	d.SetId(id)
+	return append(diags, setTopologiesState(d, state)...)
+}
+
+// Read looks up the topologies that were created, a topology deleted outside of Terraform is removed from network_ids,
+// and is re-created on the next apply. Project names are resolved again, since a project may be re-created under the same name.
+func resourceAutoAllocatedTopologiesRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	// from return value of providerConfigure()
+	osClient := m.(openstack.IdentityAPI)
+
+	networkClient, err := osClient.Network(ctx, getRegionNameFromResourceData(d))
+	if err != nil {
+		return addErrorDiagnostic(diags, err)
+	}
+	state := getTopologiesState(d)
+	diags = reresolveProjectNames(ctx, osClient, getDesiredProjects(d), state)
+
+	// network ID of the topologies that are found, by project ID
+	found := map[string]string{}
+	var lock sync.Mutex
+	errs := runParallel(ctx, sortedKeys(networkIDsByProjectID(state)), d.Get(parallelismAttribute).(int), func(ctx context.Context, projectID string) error {
+		topology, err := networkClient.FindAutoAllocatedTopology(ctx, projectID)
+		if err != nil {
+			return err
+		}
+		if topology != nil {
+			lock.Lock()
+			defer lock.Unlock()
+			found[projectID] = topology.NetworkID
+		}
+		return nil
+	})
+	for _, projectID := range sortedErrorKeys(errs) {
+		diags = addProjectDiagnostic(diags, diag.Error, projectID, errs[projectID])
+	}
+	if diags.HasError() {
+		return diags
+	}
+	for key := range state.networkIDs {
+		networkID, ok := found[state.projectIDs[key]]
+		if ok {
+			state.networkIDs[key] = networkID
+		} else {
+			delete(state.networkIDs, key)
+		}
+	}
+	return append(diags, setTopologiesState(d, state)...)
+}
+
+// reresolveProjectNames resolves the project names in state again, so that a project that is deleted and re-created
+// under the same name is not tracked by its old ID. A name that is not found any more is removed from state,
+// and is reported on the next apply. If projects cannot be listed, the known project IDs are kept with a warning.
+func reresolveProjectNames(ctx context.Context, osClient openstack.IdentityAPI, desired desiredProjects, state topologiesState) diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	names := desiredProjects{}
+	for key, isName := range desired {
+		if _, ok := state.projectIDs[key]; ok && isName {
+			names[key] = true
+		}
+	}
+	if len(names) == 0 {
+		return diags
+	}
+	projectIDs, _, listErr := resolveProjectIDs(ctx, osClient, names, nil)
+	if listErr != nil {
+		diagnostic := errorToDiagnostic(listErr)
+		diagnostic.Severity = diag.Warning
+		return append(diags, diagnostic)
+	}
+	for key := range names {
+		projectID, ok := projectIDs[key]
+		if !ok {
+			delete(state.projectIDs, key)
+			delete(state.networkIDs, key)
+			continue
+		}
+		state.projectIDs[key] = projectID
+	}
+	return diags
+}
+
+// Update only creates the topologies of projects that are added (or failed before), and deletes the ones of projects that are removed
+func resourceAutoAllocatedTopologiesUpdate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	// from return value of providerConfigure()
+	osClient := m.(openstack.IdentityAPI)
+
+	networkClient, err := osClient.Network(ctx, getRegionNameFromResourceData(d))
+	if err != nil {
+		return addErrorDiagnostic(diags, err)
+	}
+
+	state, diags := applyTopologies(ctx, osClient, networkClient, getTopologiesState(d), getDesiredProjects(d), d.Get(parallelismAttribute).(int))
+	return append(diags, setTopologiesState(d, state)...)
+}
+
+func resourceAutoAllocatedTopologiesDelete(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	// from return value of providerConfigure()
+	osClient := m.(openstack.IdentityAPI)
+
+	networkClient, err := osClient.Network(ctx, getRegionNameFromResourceData(d))
+	if err != nil {
+		return addErrorDiagnostic(diags, err)
+	}
+
+	// deleting is applying an empty set of projects
+	state, diags := applyTopologies(ctx, osClient, networkClient, getTopologiesState(d), desiredProjects{}, d.Get(parallelismAttribute).(int))
+	if len(state.networkIDs) == 0 {
+		return diags
+	}
+	// failure to delete is an error on destroy, topologies that are not deleted are kept in state
+	for i := range diags {
+		diags[i].Severity = diag.Error
+	}
+	return append(diags, setTopologiesState(d, state)...)
+}
+
+// CustomizeDiff plans an update when a project is added, removed, failed before or its topology is deleted outside of Terraform.
+// The outcome maps are only known after apply in that case.
+func resourceAutoAllocatedTopologiesCustomizeDiff(ctx context.Context, d *schema.ResourceDiff, m interface{}) error {
+	if d.Id() == "" {
+		// create, computed attributes are unknown already
+		return nil
+	}
+	if d.NewValueKnown(projectIDsAttribute) && d.NewValueKnown(projectNamesAttribute) {
+		desired := desiredProjectsFromSets(d.Get(projectIDsAttribute).(*schema.Set), d.Get(projectNamesAttribute).(*schema.Set))
+		networkIDs, _ := d.Get(networkIDsAttribute).(map[string]interface{})
+		// the maps are keyed as specified, so they change when a project is only written differently,
+		// the topology of such project is left alone on apply
+		unchanged := len(desired) == len(networkIDs)
+		for key := range desired {
+			if _, ok := networkIDs[key]; !ok {
+				unchanged = false
+			}
+		}
+		if unchanged {
+			return nil
+		}
+	}
+	for _, key := range []string{networkIDsAttribute, resolvedProjectIDsAttribute, failuresAttribute} {
+		err := d.SetNewComputed(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// topologiesDelta returns the projects to create the topology for (desired but without a topology),
+// and the projects to delete the topology of (with a topology but not desired), both by project ID.
+// projectIDs is the resolved project ID of each desired project, networkIDs is the existing topologies by project ID.
+func topologiesDelta(projectIDs map[string]string, networkIDs map[string]string) (toCreate []string, toDelete []string) {
+	desiredIDs := make(map[string]bool, len(projectIDs))
+	for _, projectID := range projectIDs {
+		if !desiredIDs[projectID] {
+			desiredIDs[projectID] = true
+			if _, ok := networkIDs[projectID]; !ok {
+				toCreate = append(toCreate, projectID)
+			}
+		}
+	}
+	for projectID := range networkIDs {
+		if !desiredIDs[projectID] {
+			toDelete = append(toDelete, projectID)
+		}
+	}
+	sort.Strings(toCreate)
+	sort.Strings(toDelete)
+	return toCreate, toDelete
+}
+
+// applyTopologies creates and deletes topologies in parallel so that the projects with a topology match desired,
+// a project that fails is recorded in failures without failing the other projects.
+// The failure of a project in project_ids is an error, the failure of a project in project_names is a warning
+// (e.g. a project of the list is not created yet), unless none of the desired projects succeeded.
+// Names are resolved to project IDs first and the delta is computed on project IDs, so a project that is specified
+// by both ID and name has one topology, and a project that only changes from name to ID (or back) is left alone.
+func applyTopologies(ctx context.Context, osClient openstack.IdentityAPI, networkClient openstack.NetworkAPI,
+	old topologiesState, desired desiredProjects, parallelism int) (topologiesState, diag.Diagnostics) {
+
+	var diags diag.Diagnostics
+
+	projectIDs, errs, listErr := resolveProjectIDs(ctx, osClient, desired, old.projectIDs)
+	networkIDs := networkIDsByProjectID(old)
+	toCreate, toDelete := topologiesDelta(projectIDs, networkIDs)
+	if listErr != nil {
+		// a name that cannot be resolved may be one of the projects to delete, deletion is retried on the next apply
+		toDelete = nil
+	}
+
+	// only read by the workers
+	deleting := make(map[string]bool, len(toDelete))
+	for _, projectID := range toDelete {
+		deleting[projectID] = true
+	}
+
+	var lock sync.Mutex
+	taskErrs := runParallel(ctx, append(toCreate, toDelete...), parallelism, func(ctx context.Context, projectID string) error {
+		if deleting[projectID] {
+			// Neutron does not fail to delete a topology that does not exist (e.g. deleted outside of Terraform)
+			err := networkClient.DeleteAutoAllocatedTopology(ctx, projectID)
+			if err != nil {
+				return err
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			delete(networkIDs, projectID)
+			return nil
+		}
+		topology, err := networkClient.GetAutoAllocatedTopology(ctx, projectID)
+		if err != nil {
+			return err
+		}
+		if topology == nil {
+			return fmt.Errorf("topology is nil")
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		networkIDs[projectID] = topology.NetworkID
+		return nil
+	})
+
+	state := newTopologiesState()
+	for key, projectID := range projectIDs {
+		state.projectIDs[key] = projectID
+		if networkID, ok := networkIDs[projectID]; ok {
+			state.networkIDs[key] = networkID
+		}
+		if err, ok := taskErrs[projectID]; ok {
+			errs[key] = err
+		}
+	}
+	// projects that are removed but whose topology is not deleted are kept, so that deleting is retried on the next apply
+	for key := range old.networkIDs {
+		projectID := old.projectIDs[key]
+		if _, ok := desired[key]; ok {
+			continue
+		}
+		if _, ok := networkIDs[projectID]; !ok || containsValue(projectIDs, projectID) {
+			continue
+		}
+		state.networkIDs[key] = networkIDs[projectID]
+		state.projectIDs[key] = projectID
+		if err, ok := taskErrs[projectID]; ok {
+			errs[key] = err
+		} else {
+			errs[key] = fmt.Errorf("topology is not deleted, since project names cannot be resolved, %w", listErr)
+		}
+	}
+
+	severity := diag.Warning
+	if len(desired) > 0 && !anyDesiredSucceeded(desired, state) {
+		severity = diag.Error
+	}
+	for _, key := range sortedErrorKeys(errs) {
+		state.failures[key] = errs[key].Error()
+		if isName, ok := desired[key]; ok && !isName {
+			diags = addProjectDiagnostic(diags, diag.Error, key, errs[key])
+		} else {
+			diags = addProjectDiagnostic(diags, severity, key, errs[key])
+		}
+	}
+	return state, diags
+}
+
+func anyDesiredSucceeded(desired desiredProjects, state topologiesState) bool {
+	for key := range desired {
+		if _, ok := state.networkIDs[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveProjectIDs resolves the project ID of each desired project. IDs are used as is, names that are resolved
+// before (in known) are not looked up again, and the other names are looked up in one listing of projects.
+// listErr is the error of listing projects, if any.
+func resolveProjectIDs(ctx context.Context, osClient openstack.IdentityAPI, desired desiredProjects, known map[string]string) (
+	projectIDs map[string]string, errs map[string]error, listErr error) {
+
+	projectIDs = make(map[string]string, len(desired))
+	errs = map[string]error{}
+
+	var nameToID map[string]string
+	for key, isName := range desired {
+		if !isName {
+			projectIDs[key] = key
+			continue
+		}
+		if projectID, ok := known[key]; ok && projectID != "" {
+			projectIDs[key] = projectID
+			continue
+		}
+		if listErr != nil {
+			errs[key] = listErr
+			continue
+		}
+		if nameToID == nil {
+			projects, err := osClient.ListProjects(ctx)
+			if err != nil {
+				listErr = fmt.Errorf("fail to list projects to look up project by name, %w", err)
+				errs[key] = listErr
+				continue
+			}
+			nameToID = make(map[string]string, len(projects))
+			for _, project := range projects {
+				if _, ok := nameToID[project.Name]; !ok {
+					// the first found, same as LookupProjectByName
+					nameToID[project.Name] = project.ID
+				}
+			}
+		}
+		projectID, ok := nameToID[key]
+		if !ok {
+			errs[key] = fmt.Errorf("project %s not found", key)
+			continue
+		}
+		projectIDs[key] = projectID
+	}
+	return projectIDs, errs, listErr
+}
+
+// runParallel calls fn for each key with at most parallelism calls in flight, and returns the error of each key that failed.
+// Keys that are not started when ctx is done fail with the error of ctx.
+func runParallel(ctx context.Context, keys []string, parallelism int, fn func(ctx context.Context, key string) error) map[string]error {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	errs := make(map[string]error)
+	var (
+		lock sync.Mutex
+		wg   sync.WaitGroup
+	)
+	keyChan := make(chan string)
+	for i := 0; i < parallelism && i < len(keys); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range keyChan {
+				err := ctx.Err()
+				if err == nil {
+					err = fn(ctx, key)
+				}
+				if err != nil {
+					lock.Lock()
+					errs[key] = err
+					lock.Unlock()
+				}
+			}
+		}()
+	}
+	for _, key := range keys {
+		keyChan <- key
+	}
+	close(keyChan)
+	wg.Wait()
+	return errs
+}
+
+func getDesiredProjects(d *schema.ResourceData) desiredProjects {
+	projectIDs, _ := d.Get(projectIDsAttribute).(*schema.Set)
+	projectNames, _ := d.Get(projectNamesAttribute).(*schema.Set)
+	return desiredProjectsFromSets(projectIDs, projectNames)
+}
+
+func desiredProjectsFromSets(projectIDs, projectNames *schema.Set) desiredProjects {
+	desired := desiredProjects{}
+	if projectIDs != nil {
+		for _, raw := range projectIDs.List() {
+			desired[raw.(string)] = false
+		}
+	}
+	if projectNames != nil {
+		for _, raw := range projectNames.List() {
+			desired[raw.(string)] = true
+		}
+	}
+	return desired
+}
+
+// getTopologiesState reads the outcome maps from state, the prior state is used during update since the maps are planned as unknown
+func getTopologiesState(d *schema.ResourceData) topologiesState {
+	state := newTopologiesState()
+	for attribute, dest := range map[string]map[string]string{
+		networkIDsAttribute:         state.networkIDs,
+		resolvedProjectIDsAttribute: state.projectIDs,
+		failuresAttribute:           state.failures,
+	} {
+		old, _ := d.GetChange(attribute)
+		raw, _ := old.(map[string]interface{})
+		for key, value := range raw {
+			dest[key] = value.(string)
+		}
+	}
+	return state
+}
+
+func setTopologiesState(d *schema.ResourceData, state topologiesState) diag.Diagnostics {
+	var diags diag.Diagnostics
+	values := map[string]interface{}{
+		networkIDsAttribute:         state.networkIDs,
+		resolvedProjectIDsAttribute: state.projectIDs,
+		failuresAttribute:           state.failures,
+	}
+	for key, value := range values {
+		err := d.Set(key, value)
+		if err != nil {
+			return addErrorDiagnostic(diags, err)
+		}
+	}
+	return diags
+}
+
+// addProjectDiagnostic adds the diagnostic of the error of a project, prefixed with the project
+func addProjectDiagnostic(diags diag.Diagnostics, severity diag.Severity, key string, err error) diag.Diagnostics {
+	diagnostic := errorToDiagnostic(err)
+	diagnostic.Severity = severity
+	diagnostic.Summary = fmt.Sprintf("project %s: %s", key, diagnostic.Summary)
+	return append(diags, diagnostic)
+}
+
+// newTopologiesID generates a random ID, the resource is not backed by a single entity in OpenStack
+func newTopologiesID() (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// networkIDsByProjectID returns the network ID of the topologies in state by project ID
+func networkIDsByProjectID(state topologiesState) map[string]string {
+	networkIDs := make(map[string]string, len(state.networkIDs))
+	for key, networkID := range state.networkIDs {
+		networkIDs[state.projectIDs[key]] = networkID
+	}
+	return networkIDs
+}
+
+func containsValue(m map[string]string, value string) bool {
+	for _, v := range m {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedErrorKeys(errs map[string]error) []string {
+	keys := make([]string, 0, len(errs))
+	for key := range errs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
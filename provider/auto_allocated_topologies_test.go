@@ -0,0 +1,604 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"gitlab.com/cyverse/openstack-auto-allocated-topology/openstack"
+	"gitlab.com/cyverse/openstack-auto-allocated-topology/openstack/fake"
+	"gitlab.com/cyverse/openstack-auto-allocated-topology/openstack/mock"
+)
+
+const testTopologiesResourceName = "openstack-auto-topology_auto_allocated_topologies.test"
+
+// addStudentProjects adds projects student-<n>-id / student-<n> to the fake server
+func addStudentProjects(server *fake.Server, count int) {
+	for i := 1; i <= count; i++ {
+		server.AddProject(fmt.Sprintf("student-%d-id", i), fmt.Sprintf("student-%d", i))
+	}
+}
+
+func topologyPath(projectID string) string {
+	return "/network/v2.0/auto-allocated-topology/" + projectID
+}
+
+func TestApplyTopologies(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	addStudentProjects(server, 3)
+	client := testClient(t, server, map[string]interface{}{regionAttribute: fake.RegionName})
+	networkClient, err := client.Network(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// create
+	desired := desiredProjects{fake.ProjectID: false, "student-1": true, "no-such-project": true}
+	state, diags := applyTopologies(context.Background(), client, networkClient, newTopologiesState(), desired, 2)
+	if len(diags) != 1 || diags[0].Severity != diag.Warning || !strings.Contains(diags[0].Summary, "no-such-project") {
+		t.Errorf("expected a warning for no-such-project, got %+v", diags)
+	}
+	expected := map[string]string{
+		fake.ProjectID: server.TopologyNetworkID(fake.ProjectID),
+		"student-1":    server.TopologyNetworkID("student-1-id"),
+	}
+	checkStringMap(t, "network_ids", expected, state.networkIDs)
+	checkStringMap(t, "resolved_project_ids", map[string]string{fake.ProjectID: fake.ProjectID, "student-1": "student-1-id"}, state.projectIDs)
+	if !strings.Contains(state.failures["no-such-project"], "not found") || len(state.failures) != 1 {
+		t.Errorf("expected failure of no-such-project, got %v", state.failures)
+	}
+
+	// update, only the delta is created and deleted
+	desired = desiredProjects{"student-1": true, "student-2-id": false, "student-3": true}
+	state, diags = applyTopologies(context.Background(), client, networkClient, state, desired, 2)
+	if diags.HasError() || len(diags) != 0 {
+		t.Fatalf("expected no diagnostics, got %+v", diags)
+	}
+	if server.TopologyNetworkID(fake.ProjectID) != "" {
+		t.Error("topology of removed project is not deleted")
+	}
+	expected = map[string]string{
+		"student-1":    server.TopologyNetworkID("student-1-id"),
+		"student-2-id": server.TopologyNetworkID("student-2-id"),
+		"student-3":    server.TopologyNetworkID("student-3-id"),
+	}
+	checkStringMap(t, "network_ids", expected, state.networkIDs)
+	if len(state.failures) != 0 {
+		t.Errorf("expected no failures, got %v", state.failures)
+	}
+	if count := server.RequestCount("GET", topologyPath("student-1-id")); count != 1 {
+		t.Errorf("expected topology of unchanged project to be requested once, got %d", count)
+	}
+
+	// delete all
+	state, diags = applyTopologies(context.Background(), client, networkClient, state, desiredProjects{}, 2)
+	if len(diags) != 0 || len(state.networkIDs) != 0 || len(state.projectIDs) != 0 {
+		t.Errorf("expected all topologies to be deleted, got %v, %+v", state.networkIDs, diags)
+	}
+	for _, projectID := range []string{"student-1-id", "student-2-id", "student-3-id"} {
+		if server.TopologyNetworkID(projectID) != "" {
+			t.Errorf("topology of %s is not deleted", projectID)
+		}
+	}
+}
+
+func TestApplyTopologiesRetryFailed(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	addStudentProjects(server, 3)
+	client := testClient(t, server, map[string]interface{}{regionAttribute: fake.RegionName})
+	networkClient, err := client.Network(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.InjectFault(fake.Fault{PathPrefix: topologyPath("student-2-id"), StatusCode: 403, Count: 1})
+
+	desired := desiredProjects{"student-1-id": false, "student-2-id": false, "student-3-id": false}
+	state, diags := applyTopologies(context.Background(), client, networkClient, newTopologiesState(), desired, 3)
+	if len(diags) != 1 || !strings.Contains(diags[0].Summary, "project student-2-id: permission denied") {
+		t.Errorf("expected permission denied of student-2-id, got %+v", diags)
+	}
+	if len(state.networkIDs) != 2 || state.failures["student-2-id"] == "" {
+		t.Errorf("expected the other projects to succeed, got %v, failures %v", state.networkIDs, state.failures)
+	}
+	toCreate, toDelete := topologiesDelta(state.projectIDs, networkIDsByProjectID(state))
+	if len(toCreate) != 1 || toCreate[0] != "student-2-id" || len(toDelete) != 0 {
+		t.Errorf("expected failed project in delta, got %v %v", toCreate, toDelete)
+	}
+
+	// failed project is retried
+	state, diags = applyTopologies(context.Background(), client, networkClient, state, desired, 3)
+	if len(diags) != 0 || len(state.failures) != 0 {
+		t.Fatalf("expected retry to succeed, got %+v, failures %v", diags, state.failures)
+	}
+	if state.networkIDs["student-2-id"] != server.TopologyNetworkID("student-2-id") {
+		t.Errorf("expected topology of student-2-id, got %v", state.networkIDs)
+	}
+	if count := server.RequestCount("GET", topologyPath("student-1-id")); count != 1 {
+		t.Errorf("expected succeeded project not to be requested again, got %d", count)
+	}
+}
+
+func TestApplyTopologiesNameToID(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	addStudentProjects(server, 1)
+	client := testClient(t, server, map[string]interface{}{regionAttribute: fake.RegionName})
+	networkClient, err := client.Network(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, diags := applyTopologies(context.Background(), client, networkClient, newTopologiesState(), desiredProjects{"student-1": true}, 2)
+	if len(diags) != 0 {
+		t.Fatalf("unexpected diagnostics %+v", diags)
+	}
+	networkID := server.TopologyNetworkID("student-1-id")
+
+	// same project written as ID, then as name again, is left alone
+	for _, desired := range []desiredProjects{{"student-1-id": false}, {"student-1": true}} {
+		state, diags = applyTopologies(context.Background(), client, networkClient, state, desired, 2)
+		if len(diags) != 0 {
+			t.Fatalf("unexpected diagnostics %+v", diags)
+		}
+		for key := range desired {
+			checkStringMap(t, "network_ids", map[string]string{key: networkID}, state.networkIDs)
+			checkStringMap(t, "resolved_project_ids", map[string]string{key: "student-1-id"}, state.projectIDs)
+		}
+	}
+	if server.TopologyNetworkID("student-1-id") != networkID {
+		t.Errorf("expected topology %s to be kept, got %q", networkID, server.TopologyNetworkID("student-1-id"))
+	}
+	if count := server.RequestCount("DELETE", topologyPath("student-1-id")); count != 0 {
+		t.Errorf("expected no delete, got %d", count)
+	}
+	if count := server.RequestCount("GET", topologyPath("student-1-id")); count != 1 {
+		t.Errorf("expected topology to be requested once, got %d", count)
+	}
+}
+
+func TestApplyTopologiesSameProjectTwice(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	addStudentProjects(server, 1)
+	client := testClient(t, server, map[string]interface{}{regionAttribute: fake.RegionName})
+	networkClient, err := client.Network(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	desired := desiredProjects{"student-1-id": false, "student-1": true}
+	state, diags := applyTopologies(context.Background(), client, networkClient, newTopologiesState(), desired, 2)
+	if len(diags) != 0 {
+		t.Fatalf("unexpected diagnostics %+v", diags)
+	}
+	networkID := server.TopologyNetworkID("student-1-id")
+	checkStringMap(t, "network_ids", map[string]string{"student-1-id": networkID, "student-1": networkID}, state.networkIDs)
+	if count := server.RequestCount("GET", topologyPath("student-1-id")); count != 1 {
+		t.Errorf("expected topology to be requested once, got %d", count)
+	}
+
+	// removing one of the entries keeps the topology
+	state, diags = applyTopologies(context.Background(), client, networkClient, state, desiredProjects{"student-1-id": false}, 2)
+	if len(diags) != 0 {
+		t.Fatalf("unexpected diagnostics %+v", diags)
+	}
+	checkStringMap(t, "network_ids", map[string]string{"student-1-id": networkID}, state.networkIDs)
+	if server.TopologyNetworkID("student-1-id") != networkID {
+		t.Error("topology of the project still specified is deleted")
+	}
+}
+
+func TestApplyTopologiesListProjectsFailed(t *testing.T) {
+	network := &mock.NetworkAPI{}
+	identity := &mock.IdentityAPI{
+		NetworkAPI: network,
+		ListProjectsFunc: func(ctx context.Context) ([]openstack.Project, error) {
+			return nil, fmt.Errorf("identity is down")
+		},
+	}
+	old := newTopologiesState()
+	old.networkIDs["a"] = "net-a"
+	old.projectIDs["a"] = "id-a"
+
+	// b may be the same project as a, so a is not deleted
+	state, diags := applyTopologies(context.Background(), identity, network, old, desiredProjects{"b": true}, 1)
+	if len(diags) != 2 {
+		t.Errorf("expected warnings for a and b, got %+v", diags)
+	}
+	if len(network.Calls()) != 0 {
+		t.Errorf("expected no network calls, got %+v", network.Calls())
+	}
+	checkStringMap(t, "network_ids", map[string]string{"a": "net-a"}, state.networkIDs)
+	if state.failures["a"] == "" || state.failures["b"] == "" {
+		t.Errorf("expected failures of a and b, got %v", state.failures)
+	}
+}
+
+func TestApplyTopologiesListProjectsOnce(t *testing.T) {
+	network := &mock.NetworkAPI{
+		GetAutoAllocatedTopologyFunc: func(ctx context.Context, projectID string) (*openstack.AutoAllocatedTopology, error) {
+			return &openstack.AutoAllocatedTopology{NetworkID: "net-" + projectID, ProjectID: projectID}, nil
+		},
+	}
+	identity := &mock.IdentityAPI{
+		NetworkAPI: network,
+		ListProjectsFunc: func(ctx context.Context) ([]openstack.Project, error) {
+			return []openstack.Project{{ID: "id-a", Name: "a"}, {ID: "id-b", Name: "b"}, {ID: "id-b2", Name: "b"}}, nil
+		},
+	}
+
+	desired := desiredProjects{"a": true, "b": true, "id-c": false}
+	state, diags := applyTopologies(context.Background(), identity, network, newTopologiesState(), desired, 1)
+	if len(diags) != 0 {
+		t.Fatalf("unexpected diagnostics %+v", diags)
+	}
+	checkStringMap(t, "resolved_project_ids", map[string]string{"a": "id-a", "b": "id-b", "id-c": "id-c"}, state.projectIDs)
+	if identity.CallCount("ListProjects") != 1 || identity.CallCount("LookupProjectByName") != 0 {
+		t.Errorf("expected projects to be listed once, got %+v", identity.Calls())
+	}
+	if network.CallCount("GetAutoAllocatedTopology") != 3 {
+		t.Errorf("expected 3 topologies, got %+v", network.Calls())
+	}
+}
+
+func TestApplyTopologiesSeverity(t *testing.T) {
+	tests := []struct {
+		name     string
+		desired  desiredProjects
+		failing  map[string]bool
+		severity map[string]diag.Severity
+	}{
+		{
+			"failed name is a warning",
+			desiredProjects{"id-a": false, "b": true},
+			map[string]bool{"id-b": true},
+			map[string]diag.Severity{"b": diag.Warning},
+		},
+		{
+			"failed ID is an error",
+			desiredProjects{"id-a": false, "b": true},
+			map[string]bool{"id-a": true},
+			map[string]diag.Severity{"id-a": diag.Error},
+		},
+		{
+			"all failed is an error",
+			desiredProjects{"a": true, "b": true},
+			map[string]bool{"id-a": true, "id-b": true},
+			map[string]diag.Severity{"a": diag.Error, "b": diag.Error},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			network := &mock.NetworkAPI{
+				GetAutoAllocatedTopologyFunc: func(ctx context.Context, projectID string) (*openstack.AutoAllocatedTopology, error) {
+					if tt.failing[projectID] {
+						return nil, openstack.ForbiddenError{APIError: openstack.APIError{StatusCode: 403}}
+					}
+					return &openstack.AutoAllocatedTopology{NetworkID: "net-" + projectID, ProjectID: projectID}, nil
+				},
+			}
+			identity := &mock.IdentityAPI{
+				NetworkAPI: network,
+				ListProjectsFunc: func(ctx context.Context) ([]openstack.Project, error) {
+					return []openstack.Project{{ID: "id-a", Name: "a"}, {ID: "id-b", Name: "b"}}, nil
+				},
+			}
+			_, diags := applyTopologies(context.Background(), identity, network, newTopologiesState(), tt.desired, 1)
+			if len(diags) != len(tt.severity) {
+				t.Fatalf("expected %d diagnostics, got %+v", len(tt.severity), diags)
+			}
+			for _, diagnostic := range diags {
+				key := strings.TrimPrefix(strings.SplitN(diagnostic.Summary, ":", 2)[0], "project ")
+				if severity, ok := tt.severity[key]; !ok || diagnostic.Severity != severity {
+					t.Errorf("unexpected severity of %s, %+v", key, diagnostic)
+				}
+			}
+		})
+	}
+}
+
+func TestResourceAutoAllocatedTopologiesCreateAllFailed(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	client := testClient(t, server, map[string]interface{}{regionAttribute: fake.RegionName})
+
+	d := schema.TestResourceDataRaw(t, resourceAutoAllocatedTopologiesSchema(), map[string]interface{}{
+		projectNamesAttribute: []interface{}{"no-such-project"},
+	})
+	diags := resourceAutoAllocatedTopologiesCreate(context.Background(), d, client)
+	if !diags.HasError() {
+		t.Fatalf("expected error when no project succeeded, got %s", diagnosticsToString(diags))
+	}
+	if d.Id() != "" {
+		t.Errorf("expected no state to be stored, got ID %s", d.Id())
+	}
+}
+
+func TestReresolveProjectNames(t *testing.T) {
+	projects := []openstack.Project{{ID: "id-a2", Name: "a"}}
+	identity := &mock.IdentityAPI{
+		ListProjectsFunc: func(ctx context.Context) ([]openstack.Project, error) {
+			return projects, nil
+		},
+	}
+	state := newTopologiesState()
+	for key, projectID := range map[string]string{"a": "id-a", "gone": "id-gone", "id-c": "id-c"} {
+		state.projectIDs[key] = projectID
+		state.networkIDs[key] = "net-" + projectID
+	}
+
+	// a is re-created with a new ID, gone is deleted, IDs are left alone
+	diags := reresolveProjectNames(context.Background(), identity, desiredProjects{"a": true, "gone": true, "id-c": false}, state)
+	if len(diags) != 0 {
+		t.Fatalf("unexpected diagnostics %+v", diags)
+	}
+	checkStringMap(t, "resolved_project_ids", map[string]string{"a": "id-a2", "id-c": "id-c"}, state.projectIDs)
+	checkStringMap(t, "network_ids", map[string]string{"a": "net-id-a", "id-c": "net-id-c"}, state.networkIDs)
+
+	// known IDs are kept if projects cannot be listed
+	identity.ListProjectsFunc = func(ctx context.Context) ([]openstack.Project, error) {
+		return nil, fmt.Errorf("identity is down")
+	}
+	diags = reresolveProjectNames(context.Background(), identity, desiredProjects{"a": true}, state)
+	if len(diags) != 1 || diags[0].Severity != diag.Warning {
+		t.Errorf("expected a warning, got %+v", diags)
+	}
+	if state.projectIDs["a"] != "id-a2" {
+		t.Errorf("expected known project ID to be kept, got %v", state.projectIDs)
+	}
+}
+
+func TestApplyTopologiesDeleteAlreadyDeleted(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	addStudentProjects(server, 1)
+	client := testClient(t, server, map[string]interface{}{regionAttribute: fake.RegionName})
+	networkClient, err := client.Network(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, diags := applyTopologies(context.Background(), client, networkClient, newTopologiesState(), desiredProjects{"student-1": true}, 1)
+	if len(diags) != 0 {
+		t.Fatalf("unexpected diagnostics %+v", diags)
+	}
+	deleteTopologyOnServer(t, server, "student-1-id")
+
+	state, diags = applyTopologies(context.Background(), client, networkClient, state, desiredProjects{}, 1)
+	if len(diags) != 0 || len(state.networkIDs) != 0 {
+		t.Errorf("expected topology deleted outside to be removed, got %v, %+v", state.networkIDs, diags)
+	}
+	if count := server.RequestCount("DELETE", topologyPath("student-1-id")); count != 2 {
+		t.Errorf("expected delete by resolved project ID, got %d deletes", count)
+	}
+}
+
+func TestRunParallel(t *testing.T) {
+	keys := make([]string, 20)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%02d", i)
+	}
+
+	var (
+		lock     sync.Mutex
+		inFlight int
+		maxSeen  int
+		done     []string
+	)
+	errs := runParallel(context.Background(), keys, 3, func(ctx context.Context, key string) error {
+		lock.Lock()
+		inFlight++
+		if inFlight > maxSeen {
+			maxSeen = inFlight
+		}
+		lock.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		lock.Lock()
+		defer lock.Unlock()
+		inFlight--
+		done = append(done, key)
+		if key == "key-07" {
+			return fmt.Errorf("failed")
+		}
+		return nil
+	})
+	if maxSeen > 3 || maxSeen < 2 {
+		t.Errorf("expected at most 3 in flight, got %d", maxSeen)
+	}
+	sort.Strings(done)
+	if strings.Join(done, ",") != strings.Join(keys, ",") {
+		t.Errorf("expected all keys to be processed, got %v", done)
+	}
+	if len(errs) != 1 || errs["key-07"] == nil {
+		t.Errorf("expected error of key-07, got %v", errs)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	errs = runParallel(ctx, keys, 3, func(ctx context.Context, key string) error {
+		t.Errorf("%s should not be started after context is done", key)
+		return nil
+	})
+	if len(errs) != len(keys) {
+		t.Errorf("expected all keys to fail, got %d", len(errs))
+	}
+}
+
+func TestResourceAutoAllocatedTopologiesCreate(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	addStudentProjects(server, 2)
+	client := testClient(t, server, map[string]interface{}{regionAttribute: fake.RegionName})
+
+	d := schema.TestResourceDataRaw(t, resourceAutoAllocatedTopologiesSchema(), map[string]interface{}{
+		projectIDsAttribute:   []interface{}{"student-1-id"},
+		projectNamesAttribute: []interface{}{"student-2", "no-such-project"},
+	})
+	diags := resourceAutoAllocatedTopologiesCreate(context.Background(), d, client)
+	// a project name that is not found is a warning
+	if diags.HasError() || len(diags) != 1 {
+		t.Fatal(diagnosticsToString(diags))
+	}
+	if d.Id() == "" || d.Get(regionNameAttribute) != fake.RegionName {
+		t.Errorf("expected ID and region to be set, got %q %v", d.Id(), d.Get(regionNameAttribute))
+	}
+	networkIDs := d.Get(networkIDsAttribute).(map[string]interface{})
+	if networkIDs["student-1-id"] != server.TopologyNetworkID("student-1-id") || networkIDs["student-2"] != server.TopologyNetworkID("student-2-id") {
+		t.Errorf("unexpected network_ids %v", networkIDs)
+	}
+	failures := d.Get(failuresAttribute).(map[string]interface{})
+	if len(failures) != 1 || failures["no-such-project"] == nil {
+		t.Errorf("unexpected failures %v", failures)
+	}
+}
+
+func checkStringMap(t *testing.T, name string, expected, actual map[string]string) {
+	t.Helper()
+	if len(expected) != len(actual) {
+		t.Errorf("%s: expected %v, got %v", name, expected, actual)
+		return
+	}
+	for key, value := range expected {
+		if value == "" || actual[key] != value {
+			t.Errorf("%s: expected %v, got %v", name, expected, actual)
+			return
+		}
+	}
+}
+
+func testTopologiesConfig(server *fake.Server, projectIDs, projectNames []string) string {
+	quote := func(values []string) string {
+		quoted := make([]string, len(values))
+		for i, value := range values {
+			quoted[i] = fmt.Sprintf("%q", value)
+		}
+		return "[" + strings.Join(quoted, ", ") + "]"
+	}
+	return testProviderConfig(server, fake.RegionName) + fmt.Sprintf(`
+resource "openstack-auto-topology_auto_allocated_topologies" "test" {
+  project_ids   = %s
+  project_names = %s
+  parallelism   = 2
+}
+`, quote(projectIDs), quote(projectNames))
+}
+
+// testCheckTopologies checks that network_ids in state are the topologies of the projects on the fake server
+func testCheckTopologies(server *fake.Server, projectIDs map[string]string) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		rs, ok := s.RootModule().Resources[testTopologiesResourceName]
+		if !ok {
+			return fmt.Errorf("%s not found in state", testTopologiesResourceName)
+		}
+		if count := rs.Primary.Attributes[networkIDsAttribute+".%"]; count != fmt.Sprint(len(projectIDs)) {
+			return fmt.Errorf("expected %d topologies, got %s", len(projectIDs), count)
+		}
+		for key, projectID := range projectIDs {
+			networkID := server.TopologyNetworkID(projectID)
+			if networkID == "" || rs.Primary.Attributes[networkIDsAttribute+"."+key] != networkID {
+				return fmt.Errorf("expected network %q of %s, got %q", networkID, key, rs.Primary.Attributes[networkIDsAttribute+"."+key])
+			}
+		}
+		return nil
+	}
+}
+
+func TestAccAutoAllocatedTopologies(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	addStudentProjects(server, 4)
+
+	resource.Test(t, resource.TestCase{
+		ProviderFactories: testProviderFactories(),
+		CheckDestroy:      testCheckNoTopology(server, "student-1-id", "student-2-id", "student-3-id", "student-4-id"),
+		Steps: []resource.TestStep{
+			{
+				Config: testTopologiesConfig(server, []string{"student-1-id"}, []string{"student-2", "student-3"}),
+				Check: resource.ComposeTestCheckFunc(
+					testCheckTopologies(server, map[string]string{"student-1-id": "student-1-id", "student-2": "student-2-id", "student-3": "student-3-id"}),
+					resource.TestCheckResourceAttr(testTopologiesResourceName, failuresAttribute+".%", "0"),
+					resource.TestCheckResourceAttr(testTopologiesResourceName, resolvedProjectIDsAttribute+".student-2", "student-2-id"),
+				),
+			},
+			{
+				// only the delta is created and deleted
+				Config: testTopologiesConfig(server, []string{"student-1-id", "student-4-id"}, []string{"student-2"}),
+				Check: resource.ComposeTestCheckFunc(
+					testCheckTopologies(server, map[string]string{"student-1-id": "student-1-id", "student-4-id": "student-4-id", "student-2": "student-2-id"}),
+					testCheckNoTopology(server, "student-3-id"),
+					func(s *terraform.State) error {
+						if count := server.RequestCount("GET", topologyPath("student-1-id")); count != 1 {
+							return fmt.Errorf("expected topology of unchanged project to be requested once, got %d", count)
+						}
+						return nil
+					},
+				),
+			},
+			{
+				// topology deleted outside of Terraform is re-created
+				PreConfig: func() {
+					deleteTopologyOnServer(t, server, "student-4-id")
+				},
+				Config: testTopologiesConfig(server, []string{"student-1-id", "student-4-id"}, []string{"student-2"}),
+				Check:  testCheckTopologies(server, map[string]string{"student-1-id": "student-1-id", "student-4-id": "student-4-id", "student-2": "student-2-id"}),
+			},
+			{
+				// project switched from name to ID keeps its topology
+				Config: testTopologiesConfig(server, []string{"student-1-id", "student-4-id", "student-2-id"}, nil),
+				Check: resource.ComposeTestCheckFunc(
+					testCheckTopologies(server, map[string]string{"student-1-id": "student-1-id", "student-4-id": "student-4-id", "student-2-id": "student-2-id"}),
+					func(s *terraform.State) error {
+						if count := server.RequestCount("DELETE", topologyPath("student-2-id")); count != 0 {
+							return fmt.Errorf("expected topology of student-2 not to be deleted, got %d deletes", count)
+						}
+						return nil
+					},
+				),
+			},
+		},
+	})
+}
+
+func TestAccAutoAllocatedTopologiesPartialFailure(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	addStudentProjects(server, 2)
+
+	resource.Test(t, resource.TestCase{
+		ProviderFactories: testProviderFactories(),
+		CheckDestroy:      testCheckNoTopology(server, "student-1-id", "student-2-id"),
+		Steps: []resource.TestStep{
+			{
+				PreConfig: func() {
+					server.InjectFault(fake.Fault{PathPrefix: topologyPath("student-2-id"), StatusCode: 403, Count: 1})
+				},
+				// failure of a project in project_names is a warning
+				Config: testTopologiesConfig(server, []string{"student-1-id"}, []string{"student-2"}),
+				Check: resource.ComposeTestCheckFunc(
+					testCheckTopologies(server, map[string]string{"student-1-id": "student-1-id"}),
+					resource.TestCheckResourceAttrSet(testTopologiesResourceName, failuresAttribute+".student-2"),
+				),
+				ExpectNonEmptyPlan: true,
+			},
+			{
+				// failed project is retried
+				Config: testTopologiesConfig(server, []string{"student-1-id"}, []string{"student-2"}),
+				Check: resource.ComposeTestCheckFunc(
+					testCheckTopologies(server, map[string]string{"student-1-id": "student-1-id", "student-2": "student-2-id"}),
+					resource.TestCheckResourceAttr(testTopologiesResourceName, failuresAttribute+".%", "0"),
+				),
+			},
+		},
+	})
+}
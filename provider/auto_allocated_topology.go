@@ -6,53 +6,169 @@ import (
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"gitlab.com/cyverse/openstack-auto-allocated-topology/openstack"
+	"strings"
+	"time"
 )
 
 func resourceAutoAllocatedTopology() *schema.Resource {
 	return &schema.Resource{
-		Description:   "Use this data source to get the auto allocated topology of current project",
+		Description:   "Use this resource to manage the auto allocated topology of a project, ID of the resource is <region>/<project ID>. The topology is looked up by its router (auto_allocated_router), renaming the router makes the topology look deleted",
 		CreateContext: resourceAutoAllocatedTopologyCreate,
 		ReadContext:   resourceAutoAllocatedTopologyRead,
-		UpdateContext: resourceAutoAllocatedTopologyUpdate,
 		DeleteContext: resourceAutoAllocatedTopologyDelete,
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceAutoAllocatedTopologyImport,
+		},
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(5 * time.Minute),
+			Read:   schema.DefaultTimeout(2 * time.Minute),
+			Delete: schema.DefaultTimeout(5 * time.Minute),
+		},
 		SchemaVersion: 1,
-		Schema:        autoAllocatedTopologySchema,
+		Schema:        resourceAutoAllocatedTopologySchema(),
 	}
 }
 
-func resourceAutoAllocatedTopologyCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
-	return dataSourceAutoAllocatedTopologyRead(ctx, d, m)
+// schema of resource is the common schema, with project and region forcing replacement when changed.
+// project ID and region name are also computed, since they are resolved from the credential if not specified.
+func resourceAutoAllocatedTopologySchema() map[string]*schema.Schema {
+	result := make(map[string]*schema.Schema, len(autoAllocatedTopologySchema))
+	for key, value := range autoAllocatedTopologySchema {
+		result[key] = value
+	}
+	result[projectIDAttribute] = &schema.Schema{
+		Type:        schema.TypeString,
+		Optional:    true,
+		Computed:    true,
+		ForceNew:    true,
+		Description: "project ID of the auto allocated topology, defaults to the project of the credential",
+	}
+	result[projectNameAttribute] = &schema.Schema{
+		Type:        schema.TypeString,
+		Optional:    true,
+		ForceNew:    true,
+		Description: "project name of the auto allocated topology",
+	}
+	result[regionNameAttribute] = &schema.Schema{
+		Type:        schema.TypeString,
+		Optional:    true,
+		Computed:    true,
+		ForceNew:    true,
+		Description: "region name of the auto allocated topology, defaults to the region of the provider",
+	}
+	return result
 }
 
-func resourceAutoAllocatedTopologyRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
-	return dataSourceAutoAllocatedTopologyRead(ctx, d, m)
+func resourceAutoAllocatedTopologyCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	diags := readAutoAllocatedTopology(ctx, d, m, true)
+	if diags.HasError() {
+		return diags
+	}
+	return setResourceID(d, m)
 }
 
-func resourceAutoAllocatedTopologyUpdate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
-	return dataSourceAutoAllocatedTopologyRead(ctx, d, m)
+// Read does not create the topology, if the topology is deleted outside of Terraform,
+// the resource is removed from state so that the plan shows a re-create.
+func resourceAutoAllocatedTopologyRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	diags := readAutoAllocatedTopology(ctx, d, m, false)
+	if diags.HasError() {
+		return diags
+	}
+	if d.Id() == "" {
+		// topology no longer exists, ID is already cleared by readAutoAllocatedTopology()
+		return diags
+	}
+	return setResourceID(d, m)
 }
 
 func resourceAutoAllocatedTopologyDelete(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	var diags diag.Diagnostics
 
 	// from return value of providerConfigure()
-	osClient := m.(openstack.Client)
+	osClient := m.(openstack.IdentityAPI)
 
-	networkClient, err := osClient.Network(getRegionNameFromResourceData(d))
+	networkClient, err := osClient.Network(ctx, getRegionNameFromResourceData(d))
 	if err != nil {
 		return addErrorDiagnostic(diags, err)
 	}
-	projectID, err := getProjectID(d, &osClient)
+	projectID, err := getProjectID(ctx, d, osClient)
 	if err != nil {
 		return addErrorDiagnostic(diags, err)
 	}
 	if projectID == "" {
 		return addErrorDiagnostic(diags, fmt.Errorf("cannot obtain project ID"))
 	}
-	err = networkClient.DeleteAutoAllocatedTopology(projectID)
+	// Neutron does not fail to delete a topology that does not exist (e.g. deleted outside of Terraform)
+	err = networkClient.DeleteAutoAllocatedTopology(ctx, projectID)
 	if err != nil {
 		return addErrorDiagnostic(diags, err)
 	}
 
 	return diags
 }
+
+// import an existing topology by project ID, or by "<region>/<project ID>".
+// Existing topology is looked up without creating one.
+func resourceAutoAllocatedTopologyImport(ctx context.Context, d *schema.ResourceData, m interface{}) ([]*schema.ResourceData, error) {
+	regionName, projectID, err := parseImportID(d.Id())
+	if err != nil {
+		return nil, err
+	}
+	if regionName != "" {
+		err = d.Set(regionNameAttribute, regionName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = d.Set(projectIDAttribute, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	diags := readAutoAllocatedTopology(ctx, d, m, false)
+	if diags.HasError() {
+		return nil, fmt.Errorf("fail to import auto allocated topology of project %s, %s", projectID, diagnosticsToString(diags))
+	}
+	if d.Id() == "" {
+		return nil, fmt.Errorf("project %s does not have an auto allocated topology", projectID)
+	}
+	diags = setResourceID(d, m)
+	if diags.HasError() {
+		return nil, fmt.Errorf("fail to import auto allocated topology of project %s, %s", projectID, diagnosticsToString(diags))
+	}
+	return []*schema.ResourceData{d}, nil
+}
+
+// setResourceID sets the ID of resource to <region>/<project ID>, and saves the resolved region name.
+// If there is no region, then the ID is just the project ID.
+func setResourceID(d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	// from return value of providerConfigure()
+	osClient := m.(openstack.IdentityAPI)
+	regionName := getRegionName(d, osClient)
+
+	err := d.Set(regionNameAttribute, regionName)
+	if err != nil {
+		return addErrorDiagnostic(diags, err)
+	}
+	projectID := getProjectIDFromResourceData(d)
+	if regionName == "" {
+		d.SetId(projectID)
+	} else {
+		d.SetId(regionName + "/" + projectID)
+	}
+	return diags
+}
+
+// parseImportID parses import (and resource) ID in the format of "<project ID>" or "<region>/<project ID>"
+func parseImportID(importID string) (regionName string, projectID string, err error) {
+	parts := strings.Split(importID, "/")
+	switch {
+	case len(parts) == 1 && parts[0] != "":
+		return "", parts[0], nil
+	case len(parts) == 2 && parts[0] != "" && parts[1] != "":
+		return parts[0], parts[1], nil
+	}
+	return "", "", fmt.Errorf("import ID %s is invalid, expect <project ID> or <region>/<project ID>", importID)
+}
@@ -0,0 +1,233 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"gitlab.com/cyverse/openstack-auto-allocated-topology/openstack"
+	"gitlab.com/cyverse/openstack-auto-allocated-topology/openstack/fake"
+	"gitlab.com/cyverse/openstack-auto-allocated-topology/openstack/mock"
+)
+
+const testResourceName = "openstack-auto-topology_auto_allocated_topology.test"
+
+func testResourceConfigByID(server *fake.Server, projectID string) string {
+	return testProviderConfig(server, fake.RegionName) + fmt.Sprintf(`
+resource "openstack-auto-topology_auto_allocated_topology" "test" {
+  project_id = "%s"
+}
+`, projectID)
+}
+
+func testResourceConfigByName(server *fake.Server, projectName string) string {
+	return testProviderConfig(server, fake.RegionName) + fmt.Sprintf(`
+resource "openstack-auto-topology_auto_allocated_topology" "test" {
+  project_name = "%s"
+}
+`, projectName)
+}
+
+// testCheckTopologyNetwork checks that network_id in state is the topology of the project on the fake server
+func testCheckTopologyNetwork(server *fake.Server, projectID string) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		rs, ok := s.RootModule().Resources[testResourceName]
+		if !ok {
+			return fmt.Errorf("%s not found in state", testResourceName)
+		}
+		networkID := server.TopologyNetworkID(projectID)
+		if networkID == "" {
+			return fmt.Errorf("project %s does not have a topology", projectID)
+		}
+		if rs.Primary.Attributes[networkIDAttribute] != networkID {
+			return fmt.Errorf("expected network %s, got %s", networkID, rs.Primary.Attributes[networkIDAttribute])
+		}
+		return nil
+	}
+}
+
+func testCheckNoTopology(server *fake.Server, projectIDs ...string) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		for _, projectID := range projectIDs {
+			if networkID := server.TopologyNetworkID(projectID); networkID != "" {
+				return fmt.Errorf("topology of project %s still exists, network %s", projectID, networkID)
+			}
+		}
+		return nil
+	}
+}
+
+func TestAccAutoAllocatedTopology(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	resource.Test(t, resource.TestCase{
+		ProviderFactories: testProviderFactories(),
+		CheckDestroy:      testCheckNoTopology(server, fake.ProjectID, "fake-other-project-id"),
+		Steps: []resource.TestStep{
+			{
+				// create
+				Config: testResourceConfigByID(server, fake.ProjectID),
+				Check: resource.ComposeTestCheckFunc(
+					testCheckTopologyNetwork(server, fake.ProjectID),
+					resource.TestCheckResourceAttr(testResourceName, "id", fake.RegionName+"/"+fake.ProjectID),
+					resource.TestCheckResourceAttr(testResourceName, regionNameAttribute, fake.RegionName),
+					resource.TestCheckResourceAttr(testResourceName, topologyNameAttribute, "auto_allocated_network"),
+					resource.TestCheckResourceAttr(testResourceName, mtuAttribute, "1450"),
+					resource.TestCheckResourceAttr(testResourceName, subnetsAttribute+".#", "1"),
+					resource.TestCheckResourceAttrSet(testResourceName, routerIDAttribute),
+					resource.TestCheckResourceAttr(testResourceName, externalNetworkAttribute, fake.ExternalNetworkID),
+				),
+			},
+			{
+				// changing project replaces the topology
+				Config: testResourceConfigByName(server, "fake-other-project"),
+				Check: resource.ComposeTestCheckFunc(
+					testCheckTopologyNetwork(server, "fake-other-project-id"),
+					testCheckNoTopology(server, fake.ProjectID),
+					resource.TestCheckResourceAttr(testResourceName, projectIDAttribute, "fake-other-project-id"),
+					resource.TestCheckResourceAttr(testResourceName, "id", fake.RegionName+"/fake-other-project-id"),
+				),
+			},
+			{
+				// import by <region>/<project ID>
+				ResourceName:            testResourceName,
+				ImportState:             true,
+				ImportStateVerify:       true,
+				ImportStateVerifyIgnore: []string{projectNameAttribute},
+			},
+		},
+	})
+}
+
+func TestAccAutoAllocatedTopologyDeletedOutside(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	resource.Test(t, resource.TestCase{
+		ProviderFactories: testProviderFactories(),
+		CheckDestroy:      testCheckNoTopology(server, fake.ProjectID),
+		Steps: []resource.TestStep{
+			{
+				Config: testResourceConfigByID(server, fake.ProjectID),
+				Check:  testCheckTopologyNetwork(server, fake.ProjectID),
+			},
+			{
+				// refresh removes the topology from state, and it is re-created
+				PreConfig: func() {
+					deleteTopologyOnServer(t, server, fake.ProjectID)
+				},
+				Config: testResourceConfigByID(server, fake.ProjectID),
+				Check:  testCheckTopologyNetwork(server, fake.ProjectID),
+			},
+		},
+	})
+}
+
+func TestAccAutoAllocatedTopologyImportMissing(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	resource.Test(t, resource.TestCase{
+		ProviderFactories: testProviderFactories(),
+		Steps: []resource.TestStep{
+			{
+				Config:        testResourceConfigByID(server, fake.ProjectID),
+				ResourceName:  testResourceName,
+				ImportState:   true,
+				ImportStateId: fake.RegionName + "/" + fake.ProjectID,
+				ExpectError:   regexp.MustCompile("does not have an auto allocated topology"),
+			},
+		},
+	})
+}
+
+func TestAccAutoAllocatedTopologyPrerequisiteNotMet(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.SetDefaultExternalNetwork(false)
+
+	resource.Test(t, resource.TestCase{
+		ProviderFactories: testProviderFactories(),
+		Steps: []resource.TestStep{
+			{
+				Config:      testResourceConfigByID(server, fake.ProjectID),
+				ExpectError: regexp.MustCompile("prerequisites are not met"),
+			},
+		},
+	})
+}
+
+// deleteTopologyOnServer deletes the topology of a project behind the back of Terraform
+func deleteTopologyOnServer(t *testing.T, server *fake.Server, projectID string) {
+	client := testClient(t, server, nil)
+	networkClient, err := client.Network(context.Background(), fake.RegionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = networkClient.DeleteAutoAllocatedTopology(context.Background(), projectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResourceAutoAllocatedTopologyDelete(t *testing.T) {
+	testCases := []struct {
+		name      string
+		deleteErr error
+		expectErr bool
+	}{
+		{"deleted", nil, false},
+		{"extension missing", openstack.ExtensionMissingError{APIError: openstack.APIError{StatusCode: 404}, Extension: "auto-allocated-topology"}, true},
+		{"forbidden", openstack.ForbiddenError{APIError: openstack.APIError{StatusCode: 403}}, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			network := &mock.NetworkAPI{
+				DeleteAutoAllocatedTopologyFunc: func(ctx context.Context, projectID string) error {
+					return tc.deleteErr
+				},
+			}
+			identity := &mock.IdentityAPI{ProjectID: "project-1", NetworkAPI: network}
+			d := schema.TestResourceDataRaw(t, resourceAutoAllocatedTopologySchema(), map[string]interface{}{
+				regionNameAttribute: "RegionOne",
+			})
+
+			diags := resourceAutoAllocatedTopologyDelete(context.Background(), d, identity)
+			if diags.HasError() != tc.expectErr {
+				t.Errorf("expected error %v, got %s", tc.expectErr, diagnosticsToString(diags))
+			}
+			calls := network.Calls()
+			if len(calls) != 1 || calls[0].Method != "DeleteAutoAllocatedTopology" || calls[0].Args[0] != "project-1" {
+				t.Errorf("expected topology of current project to be deleted, got %+v", calls)
+			}
+		})
+	}
+}
+
+func TestSetResourceID(t *testing.T) {
+	identity := &mock.IdentityAPI{Region: "RegionOne"}
+	d := schema.TestResourceDataRaw(t, resourceAutoAllocatedTopologySchema(), map[string]interface{}{
+		projectIDAttribute: "project-1",
+	})
+	diags := setResourceID(d, identity)
+	if diags.HasError() {
+		t.Fatal(diagnosticsToString(diags))
+	}
+	if d.Id() != "RegionOne/project-1" || d.Get(regionNameAttribute) != "RegionOne" {
+		t.Errorf("expected ID RegionOne/project-1, got %s", d.Id())
+	}
+
+	identity = &mock.IdentityAPI{}
+	diags = setResourceID(d, identity)
+	if diags.HasError() {
+		t.Fatal(diagnosticsToString(diags))
+	}
+	if d.Id() != "RegionOne/project-1" {
+		t.Errorf("expected region_name in state to be kept, got %s", d.Id())
+	}
+}
@@ -6,18 +6,32 @@ import (
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"gitlab.com/cyverse/openstack-auto-allocated-topology/openstack"
+	"strings"
+	"time"
 )
 
+// read timeout of the data source, long enough to create the topology on a busy Neutron
+var dataSourceReadTimeout = 5 * time.Minute
+
 const (
-	topologyIDAttribute   = "id"
-	topologyNameAttribute = "name"
-	projectIDAttribute    = "project_id"
-	projectNameAttribute  = "project_name"
-	regionNameAttribute   = "region_name"
+	topologyIDAttribute       = "id"
+	networkIDAttribute        = "network_id"
+	topologyNameAttribute     = "name"
+	projectIDAttribute        = "project_id"
+	projectNameAttribute      = "project_name"
+	regionNameAttribute       = "region_name"
+	createIfMissingAttribute  = "create_if_missing"
+	existsAttribute           = "exists"
+	subnetIDsAttribute        = "subnet_ids"
+	subnetsAttribute          = "subnets"
+	routerIDAttribute         = "router_id"
+	externalNetworkAttribute  = "external_network_id"
+	externalFixedIPsAttribute = "external_fixed_ips"
+	mtuAttribute              = "mtu"
 )
 
 var autoAllocatedTopologySchema = map[string]*schema.Schema{
-	topologyIDAttribute: {
+	networkIDAttribute: {
 		Type:        schema.TypeString,
 		Computed:    true,
 		Description: "network ID of the auto allocated topology",
@@ -42,77 +56,265 @@ var autoAllocatedTopologySchema = map[string]*schema.Schema{
 		Optional:    true,
 		Description: "region name of the auto allocated topology",
 	},
+	subnetIDsAttribute: {
+		Type:        schema.TypeList,
+		Computed:    true,
+		Description: "IDs of the subnets of the auto allocated topology network",
+		Elem:        &schema.Schema{Type: schema.TypeString},
+	},
+	subnetsAttribute: {
+		Type:        schema.TypeList,
+		Computed:    true,
+		Description: "subnets of the auto allocated topology network",
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"id": {
+					Type:        schema.TypeString,
+					Computed:    true,
+					Description: "subnet ID",
+				},
+				"cidr": {
+					Type:        schema.TypeString,
+					Computed:    true,
+					Description: "CIDR of the subnet",
+				},
+				"gateway_ip": {
+					Type:        schema.TypeString,
+					Computed:    true,
+					Description: "gateway IP of the subnet",
+				},
+				"ip_version": {
+					Type:        schema.TypeInt,
+					Computed:    true,
+					Description: "IP version of the subnet, 4 or 6",
+				},
+			},
+		},
+	},
+	routerIDAttribute: {
+		Type:        schema.TypeString,
+		Computed:    true,
+		Description: "ID of the router of the auto allocated topology",
+	},
+	externalNetworkAttribute: {
+		Type:        schema.TypeString,
+		Computed:    true,
+		Description: "ID of the external network that the router uses as gateway",
+	},
+	externalFixedIPsAttribute: {
+		Type:        schema.TypeList,
+		Computed:    true,
+		Description: "fixed IPs of the router on the external network",
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"subnet_id": {
+					Type:        schema.TypeString,
+					Computed:    true,
+					Description: "ID of the external subnet",
+				},
+				"ip_address": {
+					Type:        schema.TypeString,
+					Computed:    true,
+					Description: "IP address of the router on the external subnet",
+				},
+			},
+		},
+	},
+	mtuAttribute: {
+		Type:        schema.TypeInt,
+		Computed:    true,
+		Description: "MTU of the auto allocated topology network",
+	},
 }
 
 func dataSourceAutoAllocatedTopology() *schema.Resource {
 	return &schema.Resource{
-		Description:   "Use this data source to get the auto allocated topology of current project",
-		ReadContext:   dataSourceAutoAllocatedTopologyRead,
+		Description: "Use this data source to get the auto allocated topology of current project",
+		ReadContext: dataSourceAutoAllocatedTopologyRead,
+		Timeouts: &schema.ResourceTimeout{
+			Read: schema.DefaultTimeout(dataSourceReadTimeout),
+		},
 		SchemaVersion: 1,
-		Schema:        autoAllocatedTopologySchema,
+		Schema:        dataSourceAutoAllocatedTopologySchema(),
 	}
 }
 
+// schema of data source is the common schema plus attributes that only applies to data source
+func dataSourceAutoAllocatedTopologySchema() map[string]*schema.Schema {
+	result := make(map[string]*schema.Schema, len(autoAllocatedTopologySchema)+2)
+	for key, value := range autoAllocatedTopologySchema {
+		result[key] = value
+	}
+	result[createIfMissingAttribute] = &schema.Schema{
+		Type:        schema.TypeBool,
+		Optional:    true,
+		Default:     true,
+		Description: "create the auto allocated topology if the project does not have one, if false then only look up existing topology",
+	}
+	result[topologyIDAttribute] = &schema.Schema{
+		Type:        schema.TypeString,
+		Computed:    true,
+		Description: "network ID of the auto allocated topology, or the project ID if the topology does not exist, use network_id and exists instead",
+	}
+	result[existsAttribute] = &schema.Schema{
+		Type:        schema.TypeBool,
+		Computed:    true,
+		Description: "whether the auto allocated topology exists, only false when create_if_missing is false and the project does not have one",
+	}
+	return result
+}
+
 func dataSourceAutoAllocatedTopologyRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	ctx, cancel := withDataSourceTimeout(ctx, dataSourceReadTimeout)
+	defer cancel()
+
+	createIfMissing, _ := d.Get(createIfMissingAttribute).(bool)
+	diags := readAutoAllocatedTopology(ctx, d, m, createIfMissing)
+	if diags.HasError() {
+		return diags
+	}
+	err := d.Set(existsAttribute, d.Id() != "")
+	if err != nil {
+		return addErrorDiagnostic(diags, err)
+	}
+	if d.Id() == "" {
+		// data source must have an ID, use project ID when there is no topology
+		d.SetId(d.Get(projectIDAttribute).(string))
+	}
+	return diags
+}
+
+// readAutoAllocatedTopology reads the auto allocated topology into d, the topology is created if absent and createIfMissing is true.
+// ID is set to the network ID, or empty if the topology does not exist and is not created.
+func readAutoAllocatedTopology(ctx context.Context, d *schema.ResourceData, m interface{}, createIfMissing bool) diag.Diagnostics {
 
 	var diags diag.Diagnostics
 
 	// from return value of providerConfigure()
-	osClient := m.(openstack.Client)
-	regionName := getRegionName(d, &osClient)
+	osClient := m.(openstack.IdentityAPI)
+	regionName := getRegionName(d, osClient)
 
-	networkClient, err := osClient.Network(regionName)
+	networkClient, err := osClient.Network(ctx, regionName)
 	if err != nil {
 		return addErrorDiagnostic(diags, err)
 	}
-	projectID, err := getProjectID(d, &osClient)
+	projectID, err := getProjectID(ctx, d, osClient)
 	if err != nil {
 		return addErrorDiagnostic(diags, err)
 	}
 	if projectID == "" {
 		return addErrorDiagnostic(diags, fmt.Errorf("cannot obtain project ID"))
 	}
-	topology, err := networkClient.GetAutoAllocatedTopology(projectID)
+	var topology *openstack.AutoAllocatedTopology
+	if createIfMissing {
+		topology, err = networkClient.GetAutoAllocatedTopology(ctx, projectID)
+		if err != nil {
+			return addErrorDiagnostic(diags, err)
+		}
+		if topology == nil {
+			return addErrorDiagnostic(diags, fmt.Errorf("topology is nil"))
+		}
+	} else {
+		topology, err = networkClient.FindAutoAllocatedTopology(ctx, projectID)
+		if err != nil {
+			return addErrorDiagnostic(diags, err)
+		}
+		if topology == nil {
+			return setMissingTopology(d, projectID)
+		}
+	}
+	err = networkClient.GetAutoAllocatedTopologyDetails(ctx, topology)
 	if err != nil {
 		return addErrorDiagnostic(diags, err)
 	}
-	if topology == nil {
-		return addErrorDiagnostic(diags, fmt.Errorf("topology is nil"))
+
+	err = d.Set(networkIDAttribute, topology.NetworkID)
+	if err != nil {
+		return addErrorDiagnostic(diags, err)
 	}
-	networkName, err := osClient.LookupNetworkName(regionName, topology.NetworkID)
+	err = d.Set(topologyNameAttribute, topology.Name)
 	if err != nil {
 		return addErrorDiagnostic(diags, err)
 	}
+	err = setTopologyDetails(d, topology)
+	if err != nil {
+		return addErrorDiagnostic(diags, err)
+	}
+	d.SetId(topology.NetworkID)
 
-	err = d.Set(topologyIDAttribute, topology.NetworkID)
+	return diags
+}
+
+// setMissingTopology clears the topology attributes when the project does not have a topology
+func setMissingTopology(d *schema.ResourceData, projectID string) diag.Diagnostics {
+	var diags diag.Diagnostics
+	err := d.Set(topologyNameAttribute, "")
 	if err != nil {
 		return addErrorDiagnostic(diags, err)
 	}
-	err = d.Set(topologyNameAttribute, networkName)
+	err = d.Set(networkIDAttribute, "")
 	if err != nil {
 		return addErrorDiagnostic(diags, err)
 	}
-	err = d.Set(projectIDAttribute, topology.ProjectID)
+	err = setTopologyDetails(d, &openstack.AutoAllocatedTopology{ProjectID: projectID})
 	if err != nil {
 		return addErrorDiagnostic(diags, err)
 	}
-	d.SetId(topology.NetworkID)
-
+	d.SetId("")
 	return diags
 }
 
+// setTopologyDetails sets project ID and the computed details of the topology (subnets, router, MTU)
+func setTopologyDetails(d *schema.ResourceData, topology *openstack.AutoAllocatedTopology) error {
+	subnetIDs := make([]string, 0, len(topology.Subnets))
+	subnets := make([]map[string]interface{}, 0, len(topology.Subnets))
+	for _, subnet := range topology.Subnets {
+		subnetIDs = append(subnetIDs, subnet.ID)
+		subnets = append(subnets, map[string]interface{}{
+			"id":         subnet.ID,
+			"cidr":       subnet.CIDR,
+			"gateway_ip": subnet.GatewayIP,
+			"ip_version": subnet.IPVersion,
+		})
+	}
+	fixedIPs := make([]map[string]interface{}, 0, len(topology.ExternalFixedIPs))
+	for _, fixedIP := range topology.ExternalFixedIPs {
+		fixedIPs = append(fixedIPs, map[string]interface{}{
+			"subnet_id":  fixedIP.SubnetID,
+			"ip_address": fixedIP.IPAddress,
+		})
+	}
+
+	values := map[string]interface{}{
+		projectIDAttribute:        topology.ProjectID,
+		subnetIDsAttribute:        subnetIDs,
+		subnetsAttribute:          subnets,
+		routerIDAttribute:         topology.RouterID,
+		externalNetworkAttribute:  topology.ExternalNetworkID,
+		externalFixedIPsAttribute: fixedIPs,
+		mtuAttribute:              topology.MTU,
+	}
+	for key, value := range values {
+		err := d.Set(key, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Look up project ID use the following hierarchy:
 // - project_id if user specified it
 // - project_name if user specified it
 // - current project associated with the credential, which may not exists (e.g. unscoped credential)
-func getProjectID(d *schema.ResourceData, osClient *openstack.Client) (string, error) {
+func getProjectID(ctx context.Context, d *schema.ResourceData, osClient openstack.IdentityAPI) (string, error) {
 	projectID := getProjectIDFromResourceData(d)
 	if projectID != "" {
 		return projectID, nil
 	}
 	projectName := getProjectNameFromResourceData(d)
 	if projectName != "" {
-		return osClient.LookupProjectByName(projectName)
+		return osClient.LookupProjectByName(ctx, projectName)
 	}
 	projectID, _ = osClient.CurrentProject()
 	return projectID, nil
@@ -139,7 +341,7 @@ func getProjectNameFromResourceData(d *schema.ResourceData) string {
 // Look up region name use the following hierarchy:
 // - region_name if user specified it
 // - current region name associated with the credential, which may not exists
-func getRegionName(d *schema.ResourceData, osClient *openstack.Client) string {
+func getRegionName(d *schema.ResourceData, osClient openstack.IdentityAPI) string {
 	regionName := getRegionNameFromResourceData(d)
 	if regionName != "" {
 		return regionName
@@ -161,5 +363,31 @@ func addDiagnostic(diags diag.Diagnostics, diag2 diag.Diagnostic) diag.Diagnosti
 }
 
 func addErrorDiagnostic(diags diag.Diagnostics, err error) diag.Diagnostics {
-	return append(diags, diag.FromErr(err)...)
+	if err == nil {
+		return diags
+	}
+	return append(diags, errorToDiagnostic(err))
+}
+
+// diagnosticsToString joins the summary and detail of error diagnostics, for contexts that only accept error (e.g. import)
+func diagnosticsToString(diags diag.Diagnostics) string {
+	var messages []string
+	for _, d := range diags {
+		if d.Severity != diag.Error {
+			continue
+		}
+		if d.Detail != "" {
+			messages = append(messages, fmt.Sprintf("%s: %s", d.Summary, d.Detail))
+		} else {
+			messages = append(messages, d.Summary)
+		}
+	}
+	return strings.Join(messages, "; ")
+}
+
+// withDataSourceTimeout bounds the read of a data source by its read timeout.
+// SDK v2 does not load the timeouts of a data source into ResourceData (the deadline it sets is 20m),
+// so data sources apply their default read timeout themselves.
+func withDataSourceTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, timeout)
 }
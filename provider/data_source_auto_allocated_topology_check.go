@@ -0,0 +1,86 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"gitlab.com/cyverse/openstack-auto-allocated-topology/openstack"
+	"time"
+)
+
+// read timeout of the check data source, dry-run does not create anything
+var checkReadTimeout = 2 * time.Minute
+
+var autoAllocatedTopologyCheckSchema = map[string]*schema.Schema{
+	projectIDAttribute: {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Computed:    true,
+		Description: "project ID to check",
+	},
+	projectNameAttribute: {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "project name to check",
+	},
+	regionNameAttribute: {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "region name to check",
+	},
+}
+
+func dataSourceAutoAllocatedTopologyCheck() *schema.Resource {
+	return &schema.Resource{
+		Description: "Use this data source to check (dry-run) whether the auto allocated topology can be created for a project, without creating anything",
+		ReadContext: dataSourceAutoAllocatedTopologyCheckRead,
+		Timeouts: &schema.ResourceTimeout{
+			Read: schema.DefaultTimeout(checkReadTimeout),
+		},
+		SchemaVersion: 1,
+		Schema:        autoAllocatedTopologyCheckSchema,
+	}
+}
+
+func dataSourceAutoAllocatedTopologyCheckRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	ctx, cancel := withDataSourceTimeout(ctx, checkReadTimeout)
+	defer cancel()
+
+	var diags diag.Diagnostics
+
+	// from return value of providerConfigure()
+	osClient := m.(openstack.IdentityAPI)
+	regionName := getRegionName(d, osClient)
+
+	networkClient, err := osClient.Network(ctx, regionName)
+	if err != nil {
+		return addErrorDiagnostic(diags, err)
+	}
+	projectID, err := getProjectID(ctx, d, osClient)
+	if err != nil {
+		return addErrorDiagnostic(diags, err)
+	}
+	if projectID == "" {
+		return addErrorDiagnostic(diags, fmt.Errorf("cannot obtain project ID"))
+	}
+	err = networkClient.ValidateAutoAllocatedTopology(ctx, projectID)
+	var prerequisiteErr openstack.AutoAllocationPrerequisiteError
+	if errors.As(err, &prerequisiteErr) {
+		// add the region to the error, network client does not know its name
+		prerequisiteErr.Region = regionName
+		err = prerequisiteErr
+	}
+	if err != nil {
+		return addErrorDiagnostic(diags, err)
+	}
+
+	err = d.Set(projectIDAttribute, projectID)
+	if err != nil {
+		return addErrorDiagnostic(diags, err)
+	}
+	d.SetId(projectID)
+
+	return diags
+}
@@ -0,0 +1,401 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"gitlab.com/cyverse/openstack-auto-allocated-topology/openstack"
+	"gitlab.com/cyverse/openstack-auto-allocated-topology/openstack/fake"
+	"gitlab.com/cyverse/openstack-auto-allocated-topology/openstack/mock"
+)
+
+const testDataSourceName = "data.openstack-auto-topology_auto_allocated_topology.test"
+
+func TestAccAutoAllocatedTopologyDataSource(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	resource.Test(t, resource.TestCase{
+		ProviderFactories: testProviderFactories(),
+		Steps: []resource.TestStep{
+			{
+				// look up only, nothing is created
+				Config: testProviderConfig(server, fake.RegionName) + `
+data "openstack-auto-topology_auto_allocated_topology" "test" {
+  create_if_missing = false
+}
+`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(testDataSourceName, existsAttribute, "false"),
+					resource.TestCheckResourceAttr(testDataSourceName, projectIDAttribute, fake.ProjectID),
+					testCheckNoTopology(server, fake.ProjectID),
+				),
+			},
+			{
+				// project_id takes priority over project_name
+				Config: testProviderConfig(server, fake.RegionName) + fmt.Sprintf(`
+data "openstack-auto-topology_auto_allocated_topology" "test" {
+  project_id   = "%s"
+  project_name = "fake-other-project"
+}
+`, fake.ProjectID),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(testDataSourceName, existsAttribute, "true"),
+					resource.TestCheckResourceAttr(testDataSourceName, projectIDAttribute, fake.ProjectID),
+					resource.TestCheckResourceAttr(testDataSourceName, topologyNameAttribute, "auto_allocated_network"),
+					testCheckNoTopology(server, "fake-other-project-id"),
+				),
+			},
+			{
+				// region_name takes priority over the region of provider
+				Config: testProviderConfig(server, "RegionTwo") + `
+data "openstack-auto-topology_auto_allocated_topology" "test" {
+  region_name  = "RegionOne"
+  project_name = "fake-other-project"
+}
+`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(testDataSourceName, projectIDAttribute, "fake-other-project-id"),
+					resource.TestCheckResourceAttrSet(testDataSourceName, networkIDAttribute),
+				),
+			},
+		},
+	})
+}
+
+func TestAccAutoAllocatedTopologyDataSourceRegionNotInCatalog(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	resource.Test(t, resource.TestCase{
+		ProviderFactories: testProviderFactories(),
+		Steps: []resource.TestStep{
+			{
+				// falls back to the region of provider, which is not in catalog
+				Config: testProviderConfig(server, "RegionTwo") + `
+data "openstack-auto-topology_auto_allocated_topology" "test" {}
+`,
+				ExpectError: regexp.MustCompile("region RegionTwo"),
+			},
+		},
+	})
+}
+
+func TestAccAutoAllocatedTopologyCheckDataSource(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.SetDefaultExternalNetwork(false)
+
+	resource.Test(t, resource.TestCase{
+		ProviderFactories: testProviderFactories(),
+		Steps: []resource.TestStep{
+			{
+				Config: testProviderConfig(server, fake.RegionName) + `
+data "openstack-auto-topology_auto_allocated_topology_check" "test" {}
+`,
+				ExpectError: regexp.MustCompile("prerequisites are not met"),
+			},
+		},
+	})
+}
+
+func TestGetProjectID(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	client := testClient(t, server, nil)
+
+	testCases := []struct {
+		name     string
+		raw      map[string]interface{}
+		expected string
+	}{
+		{"project_id", map[string]interface{}{projectIDAttribute: "explicit-project-id"}, "explicit-project-id"},
+		{"project_id over project_name", map[string]interface{}{projectIDAttribute: "explicit-project-id", projectNameAttribute: "fake-other-project"}, "explicit-project-id"},
+		{"project_name", map[string]interface{}{projectNameAttribute: "fake-other-project"}, "fake-other-project-id"},
+		{"current project", map[string]interface{}{}, fake.ProjectID},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := schema.TestResourceDataRaw(t, dataSourceAutoAllocatedTopologySchema(), tc.raw)
+			projectID, err := getProjectID(context.Background(), d, client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if projectID != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, projectID)
+			}
+		})
+	}
+
+	d := schema.TestResourceDataRaw(t, dataSourceAutoAllocatedTopologySchema(), map[string]interface{}{projectNameAttribute: "no-such-project"})
+	_, err := getProjectID(context.Background(), d, client)
+	if err == nil {
+		t.Error("expected error for unknown project name")
+	}
+}
+
+func TestGetRegionName(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	client := testClient(t, server, map[string]interface{}{regionAttribute: fake.RegionName})
+	d := schema.TestResourceDataRaw(t, dataSourceAutoAllocatedTopologySchema(), map[string]interface{}{regionNameAttribute: "RegionTwo"})
+	if region := getRegionName(d, client); region != "RegionTwo" {
+		t.Errorf("expected region_name to take priority, got %s", region)
+	}
+	d = schema.TestResourceDataRaw(t, dataSourceAutoAllocatedTopologySchema(), map[string]interface{}{})
+	if region := getRegionName(d, client); region != fake.RegionName {
+		t.Errorf("expected fallback to region of provider, got %s", region)
+	}
+
+	client = testClient(t, server, nil)
+	if region := getRegionName(d, client); region != "" {
+		t.Errorf("expected empty region, got %s", region)
+	}
+}
+
+func TestReadAutoAllocatedTopology(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	client := testClient(t, server, map[string]interface{}{regionAttribute: fake.RegionName})
+
+	d := schema.TestResourceDataRaw(t, dataSourceAutoAllocatedTopologySchema(), map[string]interface{}{})
+	diags := readAutoAllocatedTopology(context.Background(), d, client, false)
+	if diags.HasError() {
+		t.Fatal(diagnosticsToString(diags))
+	}
+	if d.Id() != "" || server.TopologyNetworkID(fake.ProjectID) != "" {
+		t.Fatal("topology should not be created when createIfMissing is false")
+	}
+
+	diags = readAutoAllocatedTopology(context.Background(), d, client, true)
+	if diags.HasError() {
+		t.Fatal(diagnosticsToString(diags))
+	}
+	if d.Id() != server.TopologyNetworkID(fake.ProjectID) {
+		t.Errorf("expected ID %s, got %s", server.TopologyNetworkID(fake.ProjectID), d.Id())
+	}
+	if d.Get(mtuAttribute).(int) != 1450 || d.Get(routerIDAttribute).(string) == "" {
+		t.Errorf("details are not set, mtu %v, router %v", d.Get(mtuAttribute), d.Get(routerIDAttribute))
+	}
+}
+
+func TestDataSourceReadTimeout(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	client := testClient(t, server, map[string]interface{}{regionAttribute: fake.RegionName})
+	_, err := client.Network(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Neutron accepts the connection but does not answer within the read timeout
+	server.InjectFault(fake.Fault{PathPrefix: "/network/v2.0/", Latency: time.Second})
+
+	defer func(topologyTimeout, checkTimeout time.Duration) {
+		dataSourceReadTimeout, checkReadTimeout = topologyTimeout, checkTimeout
+	}(dataSourceReadTimeout, checkReadTimeout)
+	dataSourceReadTimeout, checkReadTimeout = 50*time.Millisecond, 50*time.Millisecond
+
+	for name, dataSource := range map[string]*schema.Resource{
+		"topology": dataSourceAutoAllocatedTopology(),
+		"check":    dataSourceAutoAllocatedTopologyCheck(),
+	} {
+		start := time.Now()
+		_, diags := dataSource.ReadDataApply(context.Background(), &terraform.InstanceDiff{}, client)
+		if !diags.HasError() || !strings.Contains(diagnosticsToString(diags), "deadline exceeded") {
+			t.Errorf("%s: expected deadline exceeded, got %s", name, diagnosticsToString(diags))
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("%s: expected read to time out, took %s", name, elapsed)
+		}
+	}
+}
+
+func TestReadAutoAllocatedTopologyErrorDiagnostics(t *testing.T) {
+	testCases := []struct {
+		name    string
+		setup   func(server *fake.Server)
+		summary string
+		detail  string
+	}{
+		{
+			name:    "no default external network",
+			setup:   func(server *fake.Server) { server.SetDefaultExternalNetwork(false) },
+			summary: "auto allocated topology prerequisites are not met",
+			detail:  "no default external network is configured",
+		},
+		{
+			name:    "extension not enabled",
+			setup:   func(server *fake.Server) { server.SetAutoAllocation(false) },
+			summary: "Neutron extension auto-allocated-topology is not enabled",
+			detail:  "request ID: req-",
+		},
+		{
+			name: "forbidden",
+			setup: func(server *fake.Server) {
+				server.InjectFault(fake.Fault{PathPrefix: "/network/v2.0/auto-allocated-topology/", StatusCode: 403})
+			},
+			summary: "permission denied",
+			detail:  "hint: ",
+		},
+		{
+			name: "server error",
+			setup: func(server *fake.Server) {
+				server.InjectFault(fake.Fault{PathPrefix: "/network/v2.0/auto-allocated-topology/", StatusCode: 503})
+			},
+			summary: "503 Service Unavailable",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := fake.NewServer()
+			defer server.Close()
+			client := testClient(t, server, map[string]interface{}{regionAttribute: fake.RegionName})
+			tc.setup(server)
+
+			d := schema.TestResourceDataRaw(t, dataSourceAutoAllocatedTopologySchema(), map[string]interface{}{})
+			diags := readAutoAllocatedTopology(context.Background(), d, client, true)
+			if !diags.HasError() {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(diags[0].Summary, tc.summary) {
+				t.Errorf("expected summary to contain %q, got %q", tc.summary, diags[0].Summary)
+			}
+			if !strings.Contains(diags[0].Detail, tc.detail) {
+				t.Errorf("expected detail to contain %q, got %q", tc.detail, diags[0].Detail)
+			}
+		})
+	}
+}
+
+func TestErrorToDiagnosticPassThrough(t *testing.T) {
+	diagnostic := errorToDiagnostic(errors.New("plain error"))
+	if diagnostic.Summary != "plain error" {
+		t.Errorf("expected summary of plain error, got %q", diagnostic.Summary)
+	}
+	diagnostic = errorToDiagnostic(fmt.Errorf("wrapped, %w", openstack.QuotaExceededError{APIError: openstack.APIError{Type: "OverQuota", RequestID: "req-1"}}))
+	if diagnostic.Summary != "quota exceeded" || !strings.Contains(diagnostic.Detail, "req-1") {
+		t.Errorf("expected quota diagnostic with request ID, got %+v", diagnostic)
+	}
+}
+
+func TestReadAutoAllocatedTopologyWithMock(t *testing.T) {
+	network := &mock.NetworkAPI{
+		GetAutoAllocatedTopologyFunc: func(ctx context.Context, projectID string) (*openstack.AutoAllocatedTopology, error) {
+			return &openstack.AutoAllocatedTopology{NetworkID: "net-1", ProjectID: projectID}, nil
+		},
+		GetAutoAllocatedTopologyDetailsFunc: func(ctx context.Context, topology *openstack.AutoAllocatedTopology) error {
+			topology.Name = "auto_allocated_network"
+			topology.MTU = 1500
+			topology.Subnets = []openstack.TopologySubnet{{ID: "subnet-1", CIDR: "10.0.0.0/24", GatewayIP: "10.0.0.1", IPVersion: 4}}
+			topology.RouterID = "router-1"
+			return nil
+		},
+	}
+	identity := &mock.IdentityAPI{
+		ProjectID:  "project-1",
+		Region:     "RegionOne",
+		NetworkAPI: network,
+		LookupProjectByNameFunc: func(ctx context.Context, projectName string) (string, error) {
+			return "project-2", nil
+		},
+	}
+
+	d := schema.TestResourceDataRaw(t, dataSourceAutoAllocatedTopologySchema(), map[string]interface{}{
+		projectNameAttribute: "project-two",
+		regionNameAttribute:  "RegionTwo",
+	})
+	diags := readAutoAllocatedTopology(context.Background(), d, identity, true)
+	if diags.HasError() {
+		t.Fatal(diagnosticsToString(diags))
+	}
+	if d.Id() != "net-1" || d.Get(projectIDAttribute) != "project-2" || d.Get(routerIDAttribute) != "router-1" {
+		t.Errorf("unexpected state, id %s, project %v, router %v", d.Id(), d.Get(projectIDAttribute), d.Get(routerIDAttribute))
+	}
+	if d.Get(subnetIDsAttribute+".0") != "subnet-1" || d.Get(topologyNameAttribute) != "auto_allocated_network" {
+		t.Errorf("unexpected state, subnets %v, name %v", d.Get(subnetIDsAttribute), d.Get(topologyNameAttribute))
+	}
+	calls := identity.Calls()
+	if calls[0].Method != "Network" || calls[0].Args[0] != "RegionTwo" {
+		t.Errorf("expected network of region_name, got %+v", calls[0])
+	}
+	if identity.CallCount("LookupProjectByName") != 1 {
+		t.Errorf("expected project name to be looked up once, got %+v", calls)
+	}
+	if network.CallCount("FindAutoAllocatedTopology") != 0 {
+		t.Error("topology should not be looked up when creating")
+	}
+}
+
+func TestReadAutoAllocatedTopologyMissingWithMock(t *testing.T) {
+	network := &mock.NetworkAPI{}
+	identity := &mock.IdentityAPI{ProjectID: "project-1", NetworkAPI: network}
+
+	d := schema.TestResourceDataRaw(t, dataSourceAutoAllocatedTopologySchema(), map[string]interface{}{})
+	d.SetId("net-1")
+	diags := readAutoAllocatedTopology(context.Background(), d, identity, false)
+	if diags.HasError() {
+		t.Fatal(diagnosticsToString(diags))
+	}
+	if d.Id() != "" || d.Get(projectIDAttribute) != "project-1" {
+		t.Errorf("expected ID to be cleared, got %s", d.Id())
+	}
+	if network.CallCount("GetAutoAllocatedTopology") != 0 {
+		t.Error("topology should not be created when createIfMissing is false")
+	}
+}
+
+func TestReadAutoAllocatedTopologyNoProjectWithMock(t *testing.T) {
+	network := &mock.NetworkAPI{}
+	identity := &mock.IdentityAPI{NetworkAPI: network}
+
+	d := schema.TestResourceDataRaw(t, dataSourceAutoAllocatedTopologySchema(), map[string]interface{}{})
+	diags := readAutoAllocatedTopology(context.Background(), d, identity, true)
+	if !diags.HasError() || !strings.Contains(diags[0].Summary, "cannot obtain project ID") {
+		t.Errorf("expected error for unscoped credential, got %s", diagnosticsToString(diags))
+	}
+	if len(network.Calls()) != 0 {
+		t.Errorf("expected no network calls, got %+v", network.Calls())
+	}
+}
+
+func TestAutoAllocatedTopologyCheckWithMock(t *testing.T) {
+	network := &mock.NetworkAPI{
+		ValidateAutoAllocatedTopologyFunc: func(ctx context.Context, projectID string) error {
+			return openstack.AutoAllocationPrerequisiteError{
+				ProjectID: projectID,
+				Reason:    "Deployment error: No default router:external network.",
+				RequestID: "req-1",
+			}
+		},
+	}
+	identity := &mock.IdentityAPI{ProjectID: "project-1", Region: "RegionOne", NetworkAPI: network}
+
+	d := schema.TestResourceDataRaw(t, autoAllocatedTopologyCheckSchema, map[string]interface{}{})
+	diags := dataSourceAutoAllocatedTopologyCheckRead(context.Background(), d, identity)
+	if !diags.HasError() {
+		t.Fatal("expected error")
+	}
+	for _, expected := range []string{"project project-1 in region RegionOne", "request ID: req-1", "openstack network set --default"} {
+		if !strings.Contains(diags[0].Detail, expected) {
+			t.Errorf("expected detail to contain %q, got %q", expected, diags[0].Detail)
+		}
+	}
+
+	network.ValidateAutoAllocatedTopologyFunc = nil
+	diags = dataSourceAutoAllocatedTopologyCheckRead(context.Background(), d, identity)
+	if diags.HasError() {
+		t.Fatal(diagnosticsToString(diags))
+	}
+	if d.Id() != "project-1" {
+		t.Errorf("expected ID project-1, got %s", d.Id())
+	}
+}
@@ -0,0 +1,16 @@
+package provider
+
+import (
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"gitlab.com/cyverse/openstack-auto-allocated-topology/openstack"
+)
+
+// errorToDiagnostic maps an error to a diagnostic, errors from OpenStack API are given a summary and a remediation hint
+func errorToDiagnostic(err error) diag.Diagnostic {
+	summary, detail := openstack.DescribeError(err)
+	return diag.Diagnostic{
+		Severity: diag.Error,
+		Summary:  summary,
+		Detail:   detail,
+	}
+}
@@ -3,48 +3,348 @@ package provider
 import (
 	"context"
 	"fmt"
+	"regexp"
+	"strings"
+	"time"
 
+	"github.com/hashicorp/go-cty/cty"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
-	"github.com/kelseyhightower/envconfig"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 	"gitlab.com/cyverse/openstack-auto-allocated-topology/openstack"
 )
 
-// New -
-func New() *schema.Provider {
-	return &schema.Provider{
-		Schema: map[string]*schema.Schema{},
+const (
+	cloudAttribute                       = "cloud"
+	authURLAttribute                     = "auth_url"
+	regionAttribute                      = "region"
+	interfaceAttribute                   = "interface"
+	applicationCredentialIDAttribute     = "application_credential_id"
+	applicationCredentialSecretAttribute = "application_credential_secret"
+	applicationCredentialNameAttribute   = "application_credential_name"
+	authTypeAttribute                    = "auth_type"
+	tokenAttribute                       = "token"
+	userNameAttribute                    = "user_name"
+	userIDAttribute                      = "user_id"
+	passwordAttribute                    = "password"
+	userDomainNameAttribute              = "user_domain_name"
+	userDomainIDAttribute                = "user_domain_id"
+	projectDomainNameAttribute           = "project_domain_name"
+	projectDomainIDAttribute             = "project_domain_id"
+	caCertFileAttribute                  = "cacert_file"
+	certAttribute                        = "cert"
+	keyAttribute                         = "key"
+	insecureAttribute                    = "insecure"
+	maxRetriesAttribute                  = "max_retries"
+	maxBackoffAttribute                  = "max_backoff"
+	endpointOverridesAttribute           = "endpoint_overrides"
+	endpointInterfacesAttribute          = "endpoint_interfaces"
+	userAgentSuffixAttribute             = "user_agent_suffix"
+)
+
+// New - version is the build version of the provider, it is reported in User-Agent
+func New(version string) *schema.Provider {
+	p := &schema.Provider{
+		Schema: map[string]*schema.Schema{
+			cloudAttribute: {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "name of the cloud in clouds.yaml to load credential from, defaults to OS_CLOUD",
+			},
+			authURLAttribute: {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Identity (Keystone) URL, defaults to OS_AUTH_URL",
+			},
+			regionAttribute: {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "default region, defaults to OS_REGION_NAME",
+			},
+			interfaceAttribute: {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  "endpoint interface to use from the service catalog, defaults to OS_INTERFACE or public",
+				ValidateFunc: validation.StringInSlice([]string{"public", "internal", "admin"}, false),
+			},
+			applicationCredentialIDAttribute: {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "application credential ID, defaults to OS_APPLICATION_CREDENTIAL_ID",
+			},
+			applicationCredentialSecretAttribute: {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				Description: "application credential secret, defaults to OS_APPLICATION_CREDENTIAL_SECRET",
+			},
+			applicationCredentialNameAttribute: {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "application credential name, requires user_name (with user domain) or user_id, defaults to OS_APPLICATION_CREDENTIAL_NAME",
+			},
+			authTypeAttribute: {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  "authentication method, chosen by the supplied credential if not specified, defaults to OS_AUTH_TYPE",
+				ValidateFunc: validation.StringInSlice([]string{"v3applicationcredential", "v3token", "v3password", "applicationcredential", "token", "password"}, true),
+			},
+			tokenAttribute: {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				Description: "pre-issued token for token authentication, defaults to OS_TOKEN",
+			},
+			userNameAttribute: {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "username for password authentication, defaults to OS_USERNAME",
+			},
+			userIDAttribute: {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "user ID for password authentication, defaults to OS_USER_ID",
+			},
+			passwordAttribute: {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				Description: "password for password authentication, defaults to OS_PASSWORD",
+			},
+			userDomainNameAttribute: {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "domain name of the user, defaults to OS_USER_DOMAIN_NAME",
+			},
+			userDomainIDAttribute: {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "domain ID of the user, defaults to OS_USER_DOMAIN_ID",
+			},
+			projectIDAttribute: {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "project ID to scope the token to, defaults to OS_PROJECT_ID",
+			},
+			projectNameAttribute: {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "project name to scope the token to, defaults to OS_PROJECT_NAME",
+			},
+			projectDomainNameAttribute: {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "domain name of the project, defaults to OS_PROJECT_DOMAIN_NAME",
+			},
+			projectDomainIDAttribute: {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "domain ID of the project, defaults to OS_PROJECT_DOMAIN_ID",
+			},
+			caCertFileAttribute: {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "path to a custom CA bundle to verify the server certificate, defaults to OS_CACERT",
+			},
+			certAttribute: {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "path to client certificate for TLS client authentication, defaults to OS_CERT",
+			},
+			keyAttribute: {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "path to private key of the client certificate, defaults to OS_KEY",
+			},
+			insecureAttribute: {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: "skip verification of server certificate, defaults to OS_INSECURE, an explicit false turns verification back on",
+			},
+			maxRetriesAttribute: {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      openstack.DefaultRetryPolicy().MaxRetries,
+				Description:  "max number of retries of a request to Network API on transient failures (5xx, 429, connection reset)",
+				ValidateFunc: validation.IntAtLeast(0),
+			},
+			maxBackoffAttribute: {
+				Type:             schema.TypeString,
+				Optional:         true,
+				Default:          openstack.DefaultRetryPolicy().MaxBackoff.String(),
+				Description:      "max wait between retries (e.g. 30s), also caps the wait requested by Retry-After",
+				ValidateDiagFunc: validateDuration,
+			},
+			endpointOverridesAttribute: {
+				Type:             schema.TypeMap,
+				Optional:         true,
+				Elem:             &schema.Schema{Type: schema.TypeString},
+				Description:      "URL of service by service type (network, identity), used instead of the URL in service catalog for all regions",
+				ValidateDiagFunc: validateEndpointMap(regexp.MustCompile(`^https?://`), "must be an http or https URL"),
+			},
+			endpointInterfacesAttribute: {
+				Type:             schema.TypeMap,
+				Optional:         true,
+				Elem:             &schema.Schema{Type: schema.TypeString},
+				Description:      "endpoint interface (public, internal, admin) by service type (network, identity), network defaults to interface, identity defaults to auth_url",
+				ValidateDiagFunc: validateEndpointMap(regexp.MustCompile(`^(public|internal|admin)$`), "must be one of public, internal, admin"),
+			},
+			userAgentSuffixAttribute: {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "appended to the User-Agent of requests to OpenStack API, e.g. to identify the pipeline that runs Terraform",
+			},
+		},
 		ResourcesMap: map[string]*schema.Resource{
-			"openstack-auto-topology_auto_allocated_topology": resourceAutoAllocatedTopology(),
+			"openstack-auto-topology_auto_allocated_topology":   resourceAutoAllocatedTopology(),
+			"openstack-auto-topology_auto_allocated_topologies": resourceAutoAllocatedTopologies(),
 		},
 		DataSourcesMap: map[string]*schema.Resource{
-			"openstack-auto-topology_auto_allocated_topology": dataSourceAutoAllocatedTopology(),
+			"openstack-auto-topology_auto_allocated_topology":       dataSourceAutoAllocatedTopology(),
+			"openstack-auto-topology_auto_allocated_topology_check": dataSourceAutoAllocatedTopologyCheck(),
 		},
-		ConfigureContextFunc: providerConfigure,
 	}
+	p.ConfigureContextFunc = func(ctx context.Context, d *schema.ResourceData) (interface{}, diag.Diagnostics) {
+		// TerraformVersion is only known at configure time
+		return providerConfigure(ctx, d, userAgent(version, p.TerraformVersion, d.Get(userAgentSuffixAttribute).(string)))
+	}
+	return p
 }
 
-func providerConfigure(ctx context.Context, d *schema.ResourceData) (interface{}, diag.Diagnostics) {
+func providerConfigure(ctx context.Context, d *schema.ResourceData, userAgent string) (interface{}, diag.Diagnostics) {
 	var diags diag.Diagnostics
 
 	osClient := openstack.NewClient()
-	appCred, err := loadCredentialFromEnv()
+	osClient.SetUserAgent(userAgent)
+	cred, err := loadCredential(d)
 	if err != nil {
 		return nil, diag.FromErr(err)
 	}
+	retryPolicy, err := loadRetryPolicy(d)
+	if err != nil {
+		return nil, diag.FromErr(err)
+	}
+	osClient.SetRetryPolicy(retryPolicy)
+	osClient.SetEndpointConfig(loadEndpointConfig(d))
 
-	err = osClient.Auth(appCred)
+	err = osClient.Auth(ctx, cred)
 	if err != nil {
 		return nil, diag.FromErr(err)
 	}
 	return osClient, diags
 }
 
-func loadCredentialFromEnv() (openstack.CredentialEnv, error) {
-	var cred openstack.CredentialEnv
-	err := envconfig.Process("", &cred)
+// userAgent returns the User-Agent of requests, e.g. terraform-provider-openstack-auto-topology/1.0.0 terraform/1.2.3
+func userAgent(version, terraformVersion, suffix string) string {
+	if version == "" {
+		version = "dev"
+	}
+	parts := []string{fmt.Sprintf("%s/%s", openstack.UserAgentProductName, version)}
+	if terraformVersion != "" {
+		parts = append(parts, fmt.Sprintf("terraform/%s", terraformVersion))
+	}
+	if suffix != "" {
+		parts = append(parts, suffix)
+	}
+	return strings.Join(parts, " ")
+}
+
+// load credential from clouds.yaml if a cloud is specified (with environment variables that are set on top of it),
+// otherwise from environment variables, then override with attributes specified in the provider block
+func loadCredential(d *schema.ResourceData) (openstack.CredentialEnv, error) {
+	var cloud string
+	overrideFromResourceData(d, cloudAttribute, &cloud)
+	cred, err := openstack.ResolveCredential(cloud)
 	if err != nil {
-		return openstack.CredentialEnv{}, fmt.Errorf("fail to load application credential from environment variables, %w", err)
+		return openstack.CredentialEnv{}, err
+	}
+	overrideFromResourceData(d, authURLAttribute, &cred.AuthURL)
+	overrideFromResourceData(d, regionAttribute, &cred.RegionName)
+	overrideFromResourceData(d, interfaceAttribute, &cred.Interface)
+	overrideFromResourceData(d, applicationCredentialIDAttribute, &cred.ApplicationCredentialID)
+	overrideFromResourceData(d, applicationCredentialSecretAttribute, &cred.ApplicationCredentialSecret)
+	overrideFromResourceData(d, applicationCredentialNameAttribute, &cred.ApplicationCredentialName)
+	overrideFromResourceData(d, authTypeAttribute, &cred.AuthType)
+	overrideFromResourceData(d, tokenAttribute, &cred.Token)
+	overrideFromResourceData(d, userNameAttribute, &cred.Username)
+	overrideFromResourceData(d, userIDAttribute, &cred.UserID)
+	overrideFromResourceData(d, passwordAttribute, &cred.Password)
+	overrideFromResourceData(d, userDomainNameAttribute, &cred.UserDomainName)
+	overrideFromResourceData(d, userDomainIDAttribute, &cred.UserDomainID)
+	overrideFromResourceData(d, projectIDAttribute, &cred.ProjectID)
+	overrideFromResourceData(d, projectNameAttribute, &cred.ProjectName)
+	overrideFromResourceData(d, projectDomainNameAttribute, &cred.ProjectDomainName)
+	overrideFromResourceData(d, projectDomainIDAttribute, &cred.ProjectDomainID)
+	overrideFromResourceData(d, caCertFileAttribute, &cred.CACertFile)
+	overrideFromResourceData(d, certAttribute, &cred.Cert)
+	overrideFromResourceData(d, keyAttribute, &cred.Key)
+	// an explicit false overrides OS_INSECURE or verify in clouds.yaml, so unset is told apart from false,
+	// GetRawConfig is not populated in Configure, so GetOkExists is used despite being deprecated
+	if insecure, ok := d.GetOkExists(insecureAttribute); ok {
+		cred.Insecure = insecure.(bool)
 	}
 	return cred, nil
 }
+
+func loadRetryPolicy(d *schema.ResourceData) (openstack.RetryPolicy, error) {
+	maxBackoff, err := time.ParseDuration(d.Get(maxBackoffAttribute).(string))
+	if err != nil {
+		return openstack.RetryPolicy{}, err
+	}
+	return openstack.RetryPolicy{
+		MaxRetries: d.Get(maxRetriesAttribute).(int),
+		MaxBackoff: maxBackoff,
+	}, nil
+}
+
+func loadEndpointConfig(d *schema.ResourceData) openstack.EndpointConfig {
+	return openstack.EndpointConfig{
+		Overrides:  stringMapFromResourceData(d, endpointOverridesAttribute),
+		Interfaces: stringMapFromResourceData(d, endpointInterfacesAttribute),
+	}
+}
+
+func validateDuration(value interface{}, path cty.Path) diag.Diagnostics {
+	duration, err := time.ParseDuration(value.(string))
+	if err != nil {
+		return diag.Diagnostics{{Severity: diag.Error, Summary: "invalid duration", Detail: err.Error(), AttributePath: path}}
+	}
+	if duration <= 0 {
+		return diag.Diagnostics{{Severity: diag.Error, Summary: "invalid duration", Detail: "duration must be positive", AttributePath: path}}
+	}
+	return nil
+}
+
+// validateEndpointMap validates a map keyed by service type
+func validateEndpointMap(valueRegex *regexp.Regexp, valueMessage string) schema.SchemaValidateDiagFunc {
+	keyValidator := validation.MapKeyMatch(regexp.MustCompile(`^(network|identity)$`), "must be a supported service type (network, identity)")
+	valueValidator := validation.MapValueMatch(valueRegex, valueMessage)
+	return func(value interface{}, path cty.Path) diag.Diagnostics {
+		return append(keyValidator(value, path), valueValidator(value, path)...)
+	}
+}
+
+func stringMapFromResourceData(d *schema.ResourceData, key string) map[string]string {
+	raw, ok := d.Get(key).(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	result := make(map[string]string, len(raw))
+	for k, v := range raw {
+		result[k] = v.(string)
+	}
+	return result
+}
+
+// overrideFromResourceData overrides the value pointed by dest if the attribute is specified (non-empty)
+func overrideFromResourceData(d *schema.ResourceData, key string, dest *string) {
+	raw, ok := d.GetOk(key)
+	if !ok {
+		return
+	}
+	value, ok := raw.(string)
+	if !ok || value == "" {
+		return
+	}
+	*dest = value
+}
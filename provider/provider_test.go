@@ -0,0 +1,153 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"gitlab.com/cyverse/openstack-auto-allocated-topology/openstack"
+	"gitlab.com/cyverse/openstack-auto-allocated-topology/openstack/fake"
+)
+
+const providerName = "openstack-auto-topology"
+
+// TestMain clears OS_* environment variables, so that tests only use the fake cloud configured in the provider block
+func TestMain(m *testing.M) {
+	for _, env := range os.Environ() {
+		if strings.HasPrefix(env, "OS_") {
+			os.Unsetenv(strings.SplitN(env, "=", 2)[0])
+		}
+	}
+	os.Exit(m.Run())
+}
+
+func TestProvider(t *testing.T) {
+	err := New("test").InternalValidate()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testProviderFactories() map[string]func() (*schema.Provider, error) {
+	return map[string]func() (*schema.Provider, error){
+		providerName: func() (*schema.Provider, error) {
+			return New("test"), nil
+		},
+	}
+}
+
+// testProviderConfig is the provider block that authenticates with the fake server using application credential
+func testProviderConfig(server *fake.Server, region string) string {
+	return fmt.Sprintf(`
+provider "%s" {
+  auth_url                      = "%s"
+  region                        = "%s"
+  application_credential_id     = "%s"
+  application_credential_secret = "%s"
+  max_backoff                   = "10ms"
+}
+`, providerName, server.AuthURL(), region, fake.ApplicationCredentialID, fake.ApplicationCredentialSecret)
+}
+
+// testClient configures the provider with the attributes, and returns the client that resources receive as meta
+func testClient(t *testing.T, server *fake.Server, raw map[string]interface{}) *openstack.Client {
+	t.Helper()
+	config := map[string]interface{}{
+		authURLAttribute:                     server.AuthURL(),
+		applicationCredentialIDAttribute:     fake.ApplicationCredentialID,
+		applicationCredentialSecretAttribute: fake.ApplicationCredentialSecret,
+		maxBackoffAttribute:                  "10ms",
+	}
+	for key, value := range raw {
+		config[key] = value
+	}
+	d := schema.TestResourceDataRaw(t, New("test").Schema, config)
+	meta, diags := providerConfigure(context.Background(), d, "test")
+	if diags.HasError() {
+		t.Fatalf("fail to configure provider, %s", diagnosticsToString(diags))
+	}
+	return meta.(*openstack.Client)
+}
+
+func TestProviderConfigure(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	client := testClient(t, server, map[string]interface{}{regionAttribute: fake.RegionName})
+	if client.CurrentRegion() != fake.RegionName {
+		t.Errorf("expected region %s, got %s", fake.RegionName, client.CurrentRegion())
+	}
+	projectID, _ := client.CurrentProject()
+	if projectID != fake.ProjectID {
+		t.Errorf("expected project %s, got %s", fake.ProjectID, projectID)
+	}
+}
+
+func TestProviderConfigureWrongCredential(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	d := schema.TestResourceDataRaw(t, New("test").Schema, map[string]interface{}{
+		authURLAttribute:                     server.AuthURL(),
+		applicationCredentialIDAttribute:     fake.ApplicationCredentialID,
+		applicationCredentialSecretAttribute: "wrong",
+	})
+	_, diags := providerConfigure(context.Background(), d, "test")
+	if !diags.HasError() {
+		t.Fatal("expected error for wrong credential")
+	}
+	if !strings.Contains(diagnosticsToString(diags), string(openstack.AuthMethodApplicationCredentialID)) {
+		t.Errorf("expected error to name the auth method, got %s", diagnosticsToString(diags))
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	testCases := []struct {
+		version          string
+		terraformVersion string
+		suffix           string
+		expected         string
+	}{
+		{"1.0.0", "1.2.3", "", "terraform-provider-openstack-auto-topology/1.0.0 terraform/1.2.3"},
+		{"1.0.0", "1.2.3", "ci/42", "terraform-provider-openstack-auto-topology/1.0.0 terraform/1.2.3 ci/42"},
+		{"", "", "", "terraform-provider-openstack-auto-topology/dev"},
+	}
+	for _, tc := range testCases {
+		actual := userAgent(tc.version, tc.terraformVersion, tc.suffix)
+		if actual != tc.expected {
+			t.Errorf("expected %q, got %q", tc.expected, actual)
+		}
+	}
+}
+
+func TestLoadCredentialInsecure(t *testing.T) {
+	testCases := []struct {
+		env      string
+		raw      map[string]interface{}
+		expected bool
+	}{
+		{"true", map[string]interface{}{}, true},
+		{"true", map[string]interface{}{insecureAttribute: false}, false},
+		{"", map[string]interface{}{insecureAttribute: true}, true},
+		{"", map[string]interface{}{insecureAttribute: false}, false},
+		{"", map[string]interface{}{}, false},
+	}
+	for _, tc := range testCases {
+		t.Setenv("OS_INSECURE", tc.env)
+		if tc.env == "" {
+			// restored by t.Setenv
+			os.Unsetenv("OS_INSECURE")
+		}
+		d := schema.TestResourceDataRaw(t, New("test").Schema, tc.raw)
+		cred, err := loadCredential(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cred.Insecure != tc.expected {
+			t.Errorf("OS_INSECURE=%q, config %v: expected insecure %v, got %v", tc.env, tc.raw, tc.expected, cred.Insecure)
+		}
+	}
+}